@@ -0,0 +1,59 @@
+package tinylfu
+
+// AdmissionPolicy decides whether an entry leaving the window replaces the
+// victim of the main segments when they are full.
+type AdmissionPolicy interface {
+	// Admit reports whether the candidate with key hash candidateKeyh should
+	// replace the victim with key hash victimKeyh. sketch holds the
+	// frequency estimates of both. Admit is called under the lock of a SyncT
+	// and must not use the cache.
+	Admit(candidateKeyh, victimKeyh uint64, sketch Sketch) bool
+}
+
+// Sketch is the frequency sketch of a cache as seen by an AdmissionPolicy.
+type Sketch interface {
+	// Estimate returns the estimated number of recent accesses of the key
+	// with hash keyh, at most 15.
+	Estimate(keyh uint64) uint8
+}
+
+var (
+	// AdmitTinyLFU admits an entry only if it passes the doorkeeper and its
+	// estimated frequency beats the victim's. It is the default. The
+	// doorkeeper, WithScanGuard, WithResetGrace and the cost weighting of
+	// WithMaxCost are part of this policy and don't apply to other ones.
+	AdmitTinyLFU AdmissionPolicy = tinyLFUPolicy{}
+	// AdmitAlways admits every entry leaving the window, skipping the
+	// doorkeeper and the frequency sketch, which makes the cache a plain
+	// segmented LRU whatever its size.
+	AdmitAlways AdmissionPolicy = alwaysPolicy{}
+)
+
+type tinyLFUPolicy struct{}
+
+func (tinyLFUPolicy) Admit(candidateKeyh, victimKeyh uint64, sketch Sketch) bool {
+	return sketch.Estimate(candidateKeyh) > sketch.Estimate(victimKeyh)
+}
+
+type alwaysPolicy struct{}
+
+func (alwaysPolicy) Admit(candidateKeyh, victimKeyh uint64, sketch Sketch) bool {
+	return true
+}
+
+// WithAdmissionPolicy sets how entries leaving the window are admitted to the
+// main segments. AdmitAlways suits workloads where recency predicts reuse
+// better than frequency; under scans it lets one-off keys push out the hot
+// ones, which TinyLFU admission keeps. See also WithAdmissionBypass, which
+// admits always only below a size. Until the main segments are full every
+// entry is admitted whatever the policy. A nil policy is AdmitTinyLFU.
+func WithAdmissionPolicy(policy AdmissionPolicy) Option {
+	return func(o *options) {
+		o.admission = policy
+	}
+}
+
+// Estimate implements Sketch.
+func (c *cm4) Estimate(keyh uint64) uint8 {
+	return c.estimate(keyh)
+}
@@ -0,0 +1,98 @@
+package tinylfu
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicateKey is returned by MSet with WithStrictBatches when a key occurs
+// more than once in the batch.
+var ErrDuplicateKey = errors.New("duplicate key in batch")
+
+// OpType is the kind of an Op.
+type OpType int
+
+const (
+	// OpSet stores Value under Key like Set.
+	OpSet OpType = iota
+	// OpDel removes Key like Del.
+	OpDel
+)
+
+// Op is one operation of an Apply batch.
+type Op struct {
+	Type     OpType
+	Key      string
+	Value    interface{}
+	ExpireAt time.Time
+}
+
+// Apply runs ops in order. Unlike Set, a Set op that Add would reject with
+// ErrInvalidItem stops the batch and its error is returned. Apply is not a
+// transaction: ops before the failing one stay applied.
+func (t *T) Apply(ops []Op) error {
+	for i, op := range ops {
+		switch op.Type {
+		case OpSet:
+			item := &Item{Key: op.Key, Value: op.Value, ExpireAt: op.ExpireAt}
+			if t.coalesce != nil {
+				// The write is only buffered, so check it now rather than
+				// losing the error when it's flushed.
+				if err := t.validate(item); err != nil {
+					return fmt.Errorf("op %d: %w", i, err)
+				}
+				t.Set(item)
+				continue
+			}
+			if err := t.set(item, false); err != nil {
+				return fmt.Errorf("op %d: %w", i, err)
+			}
+		case OpDel:
+			t.Del(op.Key)
+		default:
+			return fmt.Errorf("op %d: unknown op type %d", i, op.Type)
+		}
+	}
+	return nil
+}
+
+// MSet stores items in order like Set. When a key occurs more than once the
+// last occurrence wins: the earlier ones are skipped altogether, so they don't
+// touch the frequency or the version of the key and their OnEvict never
+// fires. With WithStrictBatches a duplicate key fails the whole batch with
+// ErrDuplicateKey before anything is stored.
+func (t *T) MSet(items []*Item) error {
+	last := make(map[string]int, len(items))
+	for i, item := range items {
+		if _, ok := last[item.Key]; ok && t.opts.strictBatches {
+			return fmt.Errorf("%w: %q", ErrDuplicateKey, item.Key)
+		}
+		last[item.Key] = i
+	}
+
+	for i, item := range items {
+		if last[item.Key] == i {
+			t.Set(item)
+		}
+	}
+	return nil
+}
+
+func (t *SyncT) MSet(items []*Item) error {
+	t.mu.Lock()
+	err := t.t.MSet(items)
+	t.mu.Unlock()
+
+	return err
+}
+
+// Apply runs ops in order, see T.Apply. The whole batch runs under the write
+// lock, so other operations see either none or all of the applied ops.
+func (t *SyncT) Apply(ops []Op) error {
+	t.mu.Lock()
+	err := t.t.Apply(ops)
+	t.mu.Unlock()
+
+	return err
+}
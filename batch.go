@@ -0,0 +1,94 @@
+package tinylfu
+
+import "strings"
+
+// SetMany stores items in order like a Set of each one. Unlike MSet every
+// item is stored, so for a key that occurs more than once the last value wins
+// after replacing the earlier ones.
+func (t *T) SetMany(items []*Item) {
+	for _, item := range items {
+		t.Set(item)
+	}
+}
+
+// GetMany looks up keys like a Get of each one and returns the values found.
+// Missing and expired keys are left out of the map.
+func (t *T) GetMany(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if val, ok := t.Get(key); ok {
+			values[key] = val
+		}
+	}
+	return values
+}
+
+// DelMany removes keys like a Del of each one.
+func (t *T) DelMany(keys []string) {
+	for _, key := range keys {
+		t.Del(key)
+	}
+}
+
+// DelPrefix removes every resident entry whose key starts with prefix, firing
+// OnEvict like Del, and returns how many were removed. Pending coalesced
+// writes of such keys are dropped too but not counted. It scans all keys.
+func (t *T) DelPrefix(prefix string) int {
+	if t.coalesce != nil {
+		for key := range t.coalesce.pending {
+			if strings.HasPrefix(key, prefix) {
+				t.coalesce.take(key)
+			}
+		}
+	}
+
+	var keys []string
+	for key := range t.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	var n int
+	for _, key := range keys {
+		// A callback fired by an earlier removal may have removed it.
+		if val, ok := t.data[key]; ok {
+			t.del(val)
+			n++
+		}
+	}
+	return n
+}
+
+// SetMany stores items, see T.SetMany. The lock is taken once for the whole
+// batch, so other operations see either none or all of the items.
+func (t *SyncT) SetMany(items []*Item) {
+	t.mu.Lock()
+	t.t.SetMany(items)
+	t.mu.Unlock()
+}
+
+// GetMany looks up keys, see T.GetMany. The write lock is taken once for the
+// whole batch, like Get takes it for one key, so the values are a consistent
+// view of the cache.
+func (t *SyncT) GetMany(keys []string) map[string]interface{} {
+	t.mu.Lock()
+	values := t.t.GetMany(keys)
+	t.mu.Unlock()
+
+	return values
+}
+
+func (t *SyncT) DelMany(keys []string) {
+	t.mu.Lock()
+	t.t.DelMany(keys)
+	t.mu.Unlock()
+}
+
+func (t *SyncT) DelPrefix(prefix string) int {
+	t.mu.Lock()
+	n := t.t.DelPrefix(prefix)
+	t.mu.Unlock()
+
+	return n
+}
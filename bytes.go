@@ -0,0 +1,128 @@
+package tinylfu
+
+import "github.com/vmihailenco/go-tinylfu/internal/list"
+
+// Size returns the size of the value measured when it was stored: Cost if it
+// is set, otherwise the length of a string or byte slice, the result of the
+// WithSizer function or 0 without one. It is kept with the entry, so eviction
+// callbacks can read it without measuring the value again.
+func (item *Item) Size() int64 {
+	return item.size
+}
+
+// itemSize returns the size of an item counted against the budget, see
+// Item.Size.
+func (t *T) itemSize(item *Item) int64 {
+	if item.Cost > 0 {
+		return item.Cost
+	}
+	return t.sizeOf(item.Value)
+}
+
+// TotalCost returns the summed Size of the resident entries, the amount
+// limited by WithMaxCost or WithMaxBytes.
+func (t *T) TotalCost() int64 {
+	return t.bytes
+}
+
+func (t *SyncT) TotalCost() int64 {
+	t.mu.RLock()
+	n := t.t.TotalCost()
+	t.mu.RUnlock()
+
+	return n
+}
+
+// sizeOf returns the size of a value, see Item.Size.
+func (t *T) sizeOf(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	}
+	if t.opts.sizer != nil {
+		return t.opts.sizer(value)
+	}
+	return 0
+}
+
+// fits reports whether a new item of the given size can be stored within
+// the byte budget without evicting below the minimum number of entries.
+func (t *T) fits(size int64) bool {
+	if size > t.opts.maxBytes {
+		return false
+	}
+
+	need := t.bytes + size - t.opts.maxBytes
+	left := len(t.data) + 1
+	var e *list.Element
+	for need > 0 {
+		e = t.byteVictim(e, "")
+		if e == nil || left <= t.opts.minEntries {
+			return false
+		}
+		need -= e.Value.(*Item).size
+		left--
+	}
+
+	return true
+}
+
+// shrink evicts entries other than key until the values fit in the byte
+// budget or the minimum number of entries is reached.
+func (t *T) shrink(key string) {
+	if t.opts.maxBytes <= 0 {
+		return
+	}
+	for t.bytes > t.opts.maxBytes && len(t.data) > t.opts.minEntries {
+		e := t.byteVictim(nil, key)
+		if e == nil {
+			return
+		}
+		t.evict(e)
+	}
+}
+
+// byteVictim returns the element to evict for the byte budget after e, or
+// the first one if e is nil. Entries that can't be evicted and key are
+// skipped.
+func (t *T) byteVictim(e *list.Element, key string) *list.Element {
+	lists := [...]*list.List{t.slru.one, t.slru.two, t.lru.ll}
+
+	i := 0
+	if e == nil {
+		e = lists[0].Back()
+	} else {
+		for lists[i] != t.listOf(e) {
+			i++
+		}
+		e = e.Prev()
+	}
+
+	for {
+		for ; e != nil; e = e.Prev() {
+			item := e.Value.(*Item)
+			if item.Key != key && t.canEvict(item) {
+				return e
+			}
+		}
+		i++
+		if i == len(lists) {
+			return nil
+		}
+		e = lists[i].Back()
+	}
+}
+
+// listOf returns the list holding e.
+func (t *T) listOf(e *list.Element) *list.List {
+	switch e.Value.(*Item).listid {
+	case 0:
+		return t.lru.ll
+	case 1:
+		return t.slru.one
+	default:
+		return t.slru.two
+	}
+}
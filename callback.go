@@ -0,0 +1,180 @@
+package tinylfu
+
+import "fmt"
+
+// CallbackMode selects which callbacks fire when an entry leaves the cache.
+type CallbackMode int
+
+const (
+	// CallbackBoth fires the item's OnEvict first and then the cache-level
+	// callback. It is the default.
+	CallbackBoth CallbackMode = iota
+	// CallbackItemOnly fires only the item's OnEvict.
+	CallbackItemOnly
+	// CallbackCacheOnly fires only the cache-level callback.
+	CallbackCacheOnly
+)
+
+// WithOnEvict sets a callback fired for every entry evicted for capacity,
+// rejected by admission or removed with Del, after the item's own OnEvict.
+// Expired entries go to WithOnExpire instead.
+func WithOnEvict(fn func(item *Item)) Option {
+	return func(o *options) {
+		o.onEvict = fn
+	}
+}
+
+// WithCallbackMode sets whether the item's OnEvict, the cache-level callback
+// (WithOnEvict, or WithOnExpire for expired entries) or both fire when an entry
+// leaves the cache. The mode applies the same way to evictions, expirations and
+// deletes. The default is CallbackBoth.
+func WithCallbackMode(mode CallbackMode) Option {
+	return func(o *options) {
+		o.callbackMode = mode
+	}
+}
+
+// WithCallbackRecovery recovers panics in OnEvict, WithOnEvict and
+// WithOnExpire callbacks instead of letting them unwind through the cache
+// operation that fired them. Recovered panics are counted in
+// Stats.CallbackErrors and the last one is kept in Stats.LastCallbackError, so
+// a callback releasing a resource can report a failure by panicking with an
+// error. A panic with any other value is reported as an error describing it.
+func WithCallbackRecovery() Option {
+	return func(o *options) {
+		o.recoverCallbacks = true
+	}
+}
+
+func (t *T) recoverCallback() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("callback panic: %v", r)
+	}
+	t.callbackErrors++
+	t.lastCallbackError = err
+}
+
+// EvictionReason tells why an entry left the cache, see Item.OnEvictReason.
+type EvictionReason int
+
+const (
+	// ReasonCapacity is an entry evicted to make room for another one or
+	// for the byte budget.
+	ReasonCapacity EvictionReason = iota
+	// ReasonExpired is an entry removed because its ExpireAt or WithMaxAge
+	// passed.
+	ReasonExpired
+	// ReasonDeleted is an entry removed by Del, GetAndDelete,
+	// InvalidateTag or Reset.
+	ReasonDeleted
+	// ReasonRejected is a new entry that lost admission or didn't fit the
+	// byte budget.
+	ReasonRejected
+	// ReasonReplaced is a value replaced by Set. The entry stays resident.
+	ReasonReplaced
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonExpired:
+		return "expired"
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonRejected:
+		return "rejected"
+	case ReasonReplaced:
+		return "replaced"
+	}
+	return fmt.Sprintf("EvictionReason(%d)", int(r))
+}
+
+// notify fires the callbacks of an item leaving the cache: its own OnEvict and
+// OnEvictReason and then cacheFn, as allowed by the callback mode.
+func (t *T) notify(item *Item, reason EvictionReason, cacheFn func(item *Item)) {
+	if t.opts.callbackMode != CallbackCacheOnly {
+		if item.OnEvict != nil {
+			t.callback(item.OnEvict)
+		}
+		if item.OnEvictReason != nil {
+			t.callback(func() { item.OnEvictReason(item.Key, item.Value, reason) })
+		}
+	}
+	if cacheFn != nil && t.opts.callbackMode != CallbackItemOnly {
+		t.callback(func() { cacheFn(item) })
+	}
+}
+
+// WithOnFull sets a callback fired when the cache reaches its capacity, once
+// per transition: it fires again only after the cache dropped below capacity.
+func WithOnFull(fn func()) Option {
+	return func(o *options) {
+		o.onFull = fn
+	}
+}
+
+// WithOnDrain sets a callback fired when a full cache drops below capacity
+// because entries were deleted, expired, evicted for the byte budget or
+// cleared. Like WithOnFull it fires once per transition, not on every removal
+// while below capacity.
+func WithOnDrain(fn func()) Option {
+	return func(o *options) {
+		o.onDrain = fn
+	}
+}
+
+// capacity returns the maximum number of entries.
+func (t *T) capacity() int {
+	return t.lru.cap + t.slru.onecap + t.slru.twocap
+}
+
+// checkFull fires WithOnFull or WithOnDrain if the cache crossed its capacity
+// since the last check.
+func (t *T) checkFull() {
+	full := len(t.data) >= t.capacity()
+	if full == t.full {
+		return
+	}
+	t.full = full
+
+	if full && t.opts.onFull != nil {
+		t.callback(t.opts.onFull)
+	} else if !full && t.opts.onDrain != nil {
+		t.callback(t.opts.onDrain)
+	}
+}
+
+// SetOnEvict attaches fn as the OnEvict callback of a resident entry,
+// replacing any callback it was stored with. A nil fn removes the callback.
+// It returns false if the key is missing or expired.
+func (t *T) SetOnEvict(key string, fn func()) bool {
+	t.flushPending(key)
+
+	val, ok := t.data[key]
+	if !ok {
+		return false
+	}
+
+	item := val.Value.(*Item)
+	if item.expired(t.opts.clock.Now()) {
+		return false
+	}
+
+	item.OnEvict = fn
+	return true
+}
+
+func (t *SyncT) SetOnEvict(key string, fn func()) bool {
+	t.mu.Lock()
+	ok := t.t.SetOnEvict(key, fn)
+	t.mu.Unlock()
+
+	return ok
+}
@@ -0,0 +1,46 @@
+package tinylfu
+
+// CompareAndSwapVersion replaces the value of key with newValue and increments
+// its Version, but only if the entry is resident, not expired and still at
+// expectedVersion, e.g. the version returned by GetResult. It returns false
+// otherwise, in which case the caller typically reads the entry again and
+// retries. Only the value changes: ExpireAt, Tags and the other fields are
+// kept, and it does not count as an access. A value rejected by
+// WithTypeEnforcement or WithMaxValueBytes isn't stored either.
+func (t *T) CompareAndSwapVersion(key string, expectedVersion uint64, newValue interface{}) bool {
+	t.flushPending(key)
+
+	val, ok := t.data[key]
+	if !ok {
+		return false
+	}
+
+	item := val.Value.(*Item)
+	if item.Version != expectedVersion || item.expired(t.opts.clock.Now()) {
+		return false
+	}
+	if err := t.validate(&Item{Key: key, Value: newValue}); err != nil {
+		return false
+	}
+
+	item.Value = newValue
+	item.Version++
+	size := t.itemSize(item)
+	t.bytes += size - item.size
+	item.size = size
+
+	t.vetoes = t.vetoes[:0]
+	t.shrink(key)
+
+	return true
+}
+
+// CompareAndSwapVersion checks the version and swaps the value under one
+// write lock, see T.CompareAndSwapVersion.
+func (t *SyncT) CompareAndSwapVersion(key string, expectedVersion uint64, newValue interface{}) bool {
+	t.mu.Lock()
+	ok := t.t.CompareAndSwapVersion(key, expectedVersion, newValue)
+	t.mu.Unlock()
+
+	return ok
+}
@@ -0,0 +1,99 @@
+package tinylfu
+
+import "github.com/vmihailenco/go-tinylfu/internal/list"
+
+// Clear removes all entries without firing their callbacks. Pending coalesced
+// writes are dropped as well. The frequency sketch and the doorkeeper are
+// kept, so keys that were popular before Clear still win admission. Reset
+// also fires the callbacks and forgets the access history.
+//
+// The index and the lists are replaced rather than emptied one entry at a
+// time, so no element of the old lists is reachable afterwards.
+func (t *T) Clear() {
+	t.mustNotRange()
+
+	data := make(map[string]*list.Element, t.capacity())
+	t.data = data
+	t.tags = nil
+	t.lru.data, t.lru.ll = data, t.opts.newList()
+	t.slru.data, t.slru.one, t.slru.two = data, t.opts.newList(), t.opts.newList()
+
+	t.pinned = 0
+	t.bytes = 0
+	t.vetoes = nil
+	if t.coalesce != nil {
+		t.coalesce = newCoalescer(t.opts.coalesceWindow, t.opts.coalesceSink)
+	}
+	t.checkFull()
+}
+
+// Reset removes all entries like Clear but fires their OnEvict callbacks,
+// from the window to the protected segment, and forgets the access history:
+// the frequency sketch, the doorkeeper and the distinct key estimates start
+// over as in a new cache. Hit and miss counters are kept, see ResetStats.
+func (t *T) Reset() {
+	items := make([]*Item, 0, len(t.data))
+	for _, l := range []*list.List{t.lru.ll, t.slru.one, t.slru.two} {
+		for e := l.Front(); e != nil; e = e.Next() {
+			items = append(items, e.Value.(*Item))
+		}
+	}
+
+	t.Clear()
+	t.countSketch.clear()
+	t.bouncer.reset()
+	t.resetEstimates()
+	t.w = 0
+	t.resetting = false
+
+	for _, item := range items {
+		t.trace(eventEvict, item.Key, reasonDeleted)
+		t.onEvict(item, ReasonDeleted)
+	}
+}
+
+// ResetSketch forgets the access history like Reset but keeps the entries:
+// the frequency sketch and the doorkeeper start over and the next periodic
+// aging is due after samples more Gets. Resident entries then compete for
+// admission on the accesses made after the call, which helps after a change
+// of workload phase. The distinct key estimates and Epoch are kept.
+func (t *T) ResetSketch() {
+	t.countSketch.clear()
+	t.bouncer.reset()
+	t.w = 0
+	t.resetting = false
+}
+
+// Len returns the number of resident entries, including expired entries that
+// haven't been removed yet.
+func (t *T) Len() int {
+	return len(t.data)
+}
+
+// Clear removes all entries, see T.Clear. It holds the write lock, so a
+// concurrent operation sees either the whole old contents or the empty cache.
+func (t *SyncT) Clear() {
+	t.mu.Lock()
+	t.t.Clear()
+	t.mu.Unlock()
+}
+
+func (t *SyncT) Reset() {
+	t.mu.Lock()
+	t.t.Reset()
+	t.mu.Unlock()
+}
+
+func (t *SyncT) ResetSketch() {
+	t.mu.Lock()
+	t.t.ResetSketch()
+	t.mu.Unlock()
+}
+
+func (t *SyncT) Len() int {
+	t.mu.RLock()
+	n := t.t.Len()
+	t.mu.RUnlock()
+
+	return n
+}
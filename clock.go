@@ -0,0 +1,13 @@
+package tinylfu
+
+import "time"
+
+// Clock tells the cache the current time. It is used for expiry and entry
+// ages so tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -2,29 +2,51 @@ package tinylfu
 
 // cm4 is a small conservative-update count-min sketch implementation with 4-bit counters
 type cm4 struct {
-	s    [depth]nvec
+	s    []nvec
 	mask uint32
 }
 
+// depth is the default number of rows of the sketch.
 const depth = 4
 
-func newCM4(w int) *cm4 {
+// newCM4 returns a sketch of d rows of w counters each, w rounded up to a
+// power of two.
+func newCM4(w, d int) *cm4 {
 	if w < 1 {
 		panic("cm4: bad width")
 	}
+	if d < 1 {
+		panic("cm4: bad depth")
+	}
 
-	w32 := nextPowerOfTwo(uint32(w))
+	// A row has at least one byte of counters.
+	w32 := max(nextPowerOfTwo(uint32(w)), 2)
 	c := cm4{
+		s:    make([]nvec, d),
 		mask: w32 - 1,
 	}
 
-	for i := 0; i < depth; i++ {
+	for i := range c.s {
 		c.s[i] = newNvec(int(w32))
 	}
 
 	return &c
 }
 
+// newSketch returns the frequency sketch of a cache of size entries: one
+// counter per entry in each of 4 rows unless WithSketchWidth or
+// WithSketchDepth say otherwise.
+func newSketch(size int, o *options) *cm4 {
+	w, d := size, depth
+	if o.sketchWidth > 0 {
+		w = o.sketchWidth
+	}
+	if o.sketchDepth > 0 {
+		d = o.sketchDepth
+	}
+	return newCM4(w, d)
+}
+
 func (c *cm4) add(keyh uint64) {
 	h1, h2 := uint32(keyh), uint32(keyh>>32)
 
@@ -38,7 +60,7 @@ func (c *cm4) estimate(keyh uint64) byte {
 	h1, h2 := uint32(keyh), uint32(keyh>>32)
 
 	var min byte = 255
-	for i := 0; i < depth; i++ {
+	for i := range c.s {
 		pos := (h1 + uint32(i)*h2) & c.mask
 		v := c.s[i].get(pos)
 		if v < min {
@@ -54,6 +76,35 @@ func (c *cm4) reset() {
 	}
 }
 
+// clear zeroes all counters.
+func (c *cm4) clear() {
+	for _, n := range c.s {
+		for i := range n {
+			n[i] = 0
+		}
+	}
+}
+
+// len returns the number of counter bytes in the sketch.
+func (c *cm4) len() int {
+	return len(c.s) * len(c.s[0])
+}
+
+// resetRange halves the counters stored in bytes [start, end) of the sketch,
+// numbering the bytes row by row.
+func (c *cm4) resetRange(start, end int) {
+	row := len(c.s[0])
+	for start < end {
+		n := c.s[start/row]
+		i, j := start%row, row
+		if end-start < j-i {
+			j = i + end - start
+		}
+		n[i:j].reset()
+		start += j - i
+	}
+}
+
 // nybble vector
 type nvec []byte
 
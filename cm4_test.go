@@ -1,7 +1,12 @@
 package tinylfu
 
 import (
+	"fmt"
+	"reflect"
+	"strconv"
 	"testing"
+
+	"github.com/cespare/xxhash/v2"
 )
 
 func TestNvec(t *testing.T) {
@@ -58,7 +63,7 @@ func TestNvec(t *testing.T) {
 }
 
 func TestCM4(t *testing.T) {
-	cm := newCM4(32)
+	cm := newCM4(32, depth)
 
 	hash := uint64(0x0ddc0ffeebadf00d)
 
@@ -69,3 +74,130 @@ func TestCM4(t *testing.T) {
 		t.Errorf("cm.estimate(%x)=%d, want 2\n", hash, got)
 	}
 }
+
+func TestCM4ResetRange(t *testing.T) {
+	full := newCM4(64, depth)
+	ranged := newCM4(64, depth)
+
+	for i := uint64(0); i < 1000; i++ {
+		hash := i * 0x9e3779b97f4a7c15
+		full.add(hash)
+		ranged.add(hash)
+	}
+
+	full.reset()
+	for i := 0; i < ranged.len(); i += 7 {
+		end := i + 7
+		if end > ranged.len() {
+			end = ranged.len()
+		}
+		ranged.resetRange(i, end)
+	}
+
+	for i := range full.s {
+		for j := range full.s[i] {
+			if full.s[i][j] != ranged.s[i][j] {
+				t.Fatalf("s[%d][%d]=0x%02x, want 0x%02x", i, j, ranged.s[i][j], full.s[i][j])
+			}
+		}
+	}
+}
+
+func TestIncrementalReset(t *testing.T) {
+	const samples, step = 1000, 7
+
+	inc := New(100, samples, WithIncrementalReset(step))
+	ref := New(100, samples)
+
+	// Up to the Get that triggers the reset both caches see the same
+	// accesses.
+	for i := 0; i < samples-1; i++ {
+		key := fmt.Sprintf("key-%d", i%150)
+		for _, cache := range []*T{inc, ref} {
+			cache.Set(&Item{Key: key, Value: key})
+			if got, ok := cache.Get(key); ok && got != key {
+				t.Fatalf("Get(%q) = %v", key, got)
+			}
+		}
+	}
+	if inc.resetting || inc.epoch != 0 {
+		t.Fatal("reset started early")
+	}
+
+	// Once the chunks cover the sketch and the doorkeeper, the result is
+	// the same as a synchronous reset.
+	inc.reset()
+	ref.reset()
+	inc.w = 0
+	n := max(inc.countSketch.len(), inc.bouncer.len())
+	chunks := 1
+	for ; inc.resetting; chunks++ {
+		inc.resetChunk()
+	}
+	if want := (n + step - 1) / step; chunks > want {
+		t.Fatalf("reset took %d chunks, wanted at most %d", chunks, want)
+	}
+	if !reflect.DeepEqual(inc.countSketch, ref.countSketch) {
+		t.Fatal("sketch differs from a synchronous reset")
+	}
+	if !reflect.DeepEqual(inc.bouncer, ref.bouncer) {
+		t.Fatal("doorkeeper differs from a synchronous reset")
+	}
+
+	// Driven by Gets, the Get that starts the reset does the first chunk and
+	// every later one a chunk more.
+	for i := 0; i < samples; i++ {
+		inc.Get("key")
+	}
+	if !inc.resetting {
+		t.Fatal("reset didn't start")
+	}
+	gets := 1
+	for ; inc.resetting; gets++ {
+		inc.Get("key")
+	}
+	if want := (n + step - 1) / step; gets > want {
+		t.Fatalf("reset took %d Gets, wanted at most %d", gets, want)
+	}
+}
+
+func TestSketchDimensions(t *testing.T) {
+	// Keys added once each; an estimate above 1 means the key shares all its
+	// counters with others.
+	collisions := func(opts ...Option) int {
+		var o options
+		for _, opt := range opts {
+			opt(&o)
+		}
+		c := newSketch(1000, &o)
+
+		keys := make([]uint64, 2000)
+		for i := range keys {
+			keys[i] = xxhash.Sum64String(strconv.Itoa(i))
+			c.add(keys[i])
+		}
+
+		var n int
+		for _, keyh := range keys {
+			if c.estimate(keyh) > 1 {
+				n++
+			}
+		}
+		return n
+	}
+
+	def := collisions()
+	wide := collisions(WithSketchWidth(16384))
+	deep := collisions(WithSketchDepth(8))
+	if wide >= def || deep >= def {
+		t.Fatalf("got %d collisions wide and %d deep, wanted fewer than %d", wide, deep, def)
+	}
+	if narrow := collisions(WithSketchWidth(128), WithSketchDepth(1)); narrow <= def {
+		t.Fatalf("got %d collisions narrow, wanted more than %d", narrow, def)
+	}
+
+	c := newSketch(1000, &options{sketchWidth: 100, sketchDepth: 3})
+	if len(c.s) != 3 || c.len() != 3*64 {
+		t.Fatalf("got %d rows of %d bytes, wanted 3 rows of 64", len(c.s), len(c.s[0]))
+	}
+}
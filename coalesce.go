@@ -0,0 +1,165 @@
+package tinylfu
+
+import (
+	"io"
+	"time"
+)
+
+var (
+	_ io.Closer = (*T)(nil)
+	_ io.Closer = (*SyncT)(nil)
+	_ io.Closer = (*ShardedCache)(nil)
+)
+
+// coalescer buffers Sets per key so that only the latest value is applied.
+type coalescer struct {
+	window  time.Duration
+	sink    func(item *Item)
+	pending map[string]pendingWrite
+	// queue holds the writes in the order their window closes. Entries
+	// superseded by a later write of the same key are skipped.
+	queue []pendingWrite
+}
+
+type pendingWrite struct {
+	item     *Item
+	deadline time.Time
+}
+
+func newCoalescer(window time.Duration, sink func(item *Item)) *coalescer {
+	return &coalescer{
+		window:  window,
+		sink:    sink,
+		pending: make(map[string]pendingWrite),
+	}
+}
+
+// put buffers item, replacing any pending write of the same key but keeping
+// its deadline.
+func (c *coalescer) put(item *Item, now time.Time) {
+	if w, ok := c.pending[item.Key]; ok {
+		w.item = item
+		c.pending[item.Key] = w
+		return
+	}
+
+	w := pendingWrite{item: item, deadline: now.Add(c.window)}
+	c.pending[item.Key] = w
+	c.queue = append(c.queue, w)
+}
+
+// take removes and returns the pending write of key.
+func (c *coalescer) take(key string) (*Item, bool) {
+	w, ok := c.pending[key]
+	if ok {
+		delete(c.pending, key)
+	}
+	return w.item, ok
+}
+
+// due removes and returns the next write whose window closed before now.
+func (c *coalescer) due(now time.Time, all bool) (*Item, bool) {
+	for len(c.queue) > 0 {
+		w := c.queue[0]
+		if !all && w.deadline.After(now) {
+			return nil, false
+		}
+		c.queue[0] = pendingWrite{}
+		c.queue = c.queue[1:]
+
+		if p, ok := c.pending[w.item.Key]; ok && p.deadline.Equal(w.deadline) {
+			delete(c.pending, w.item.Key)
+			return p.item, true
+		}
+	}
+	return nil, false
+}
+
+// apply stores a coalesced write in the cache and passes it to the sink.
+func (t *T) apply(item *Item) {
+	if t.set(item, false) == nil && t.coalesce.sink != nil {
+		t.coalesce.sink(item)
+	}
+}
+
+// flushDue applies the pending writes whose window closed.
+func (t *T) flushDue() {
+	if len(t.coalesce.queue) == 0 {
+		return
+	}
+	now := t.opts.clock.Now()
+	for item, ok := t.coalesce.due(now, false); ok; item, ok = t.coalesce.due(now, false) {
+		t.apply(item)
+	}
+}
+
+// flushKey applies the pending write of key, if any.
+func (t *T) flushKey(key string) {
+	if item, ok := t.coalesce.take(key); ok {
+		t.apply(item)
+	}
+}
+
+// flushPending applies the pending write of key, if any, before an operation
+// other than Get reads or changes its entry.
+func (t *T) flushPending(key string) {
+	if t.coalesce != nil {
+		t.flushKey(key)
+	}
+}
+
+// Flush applies all pending writes buffered by WithWriteCoalescing.
+func (t *T) Flush() {
+	if t.coalesce == nil {
+		return
+	}
+	for item, ok := t.coalesce.due(time.Time{}, true); ok; item, ok = t.coalesce.due(time.Time{}, true) {
+		t.apply(item)
+	}
+}
+
+// Close flushes pending writes. The cache can still be used afterwards.
+func (t *T) Close() error {
+	t.Flush()
+	return nil
+}
+
+// rlock takes the lock for an operation that only reads the cache: the read
+// lock, or the write lock with WithWriteCoalescing since reads apply pending
+// writes first.
+func (t *SyncT) rlock() {
+	if t.t.coalesce != nil {
+		t.mu.Lock()
+	} else {
+		t.mu.RLock()
+	}
+}
+
+// runlock releases the lock taken by rlock.
+func (t *SyncT) runlock() {
+	if t.t.coalesce != nil {
+		t.mu.Unlock()
+	} else {
+		t.mu.RUnlock()
+	}
+}
+
+func (t *SyncT) Flush() {
+	t.mu.Lock()
+	t.t.Flush()
+	t.mu.Unlock()
+}
+
+// Close stops the WithJanitor goroutine, see Stop, and flushes pending
+// writes. Callbacks fired by the flush have returned when it returns, and no
+// goroutine of the cache is left running. Close can be called more than once,
+// and the cache can still be used afterwards without the janitor.
+func (t *SyncT) Close() error {
+	t.Stop()
+
+	t.mu.Lock()
+	err := t.t.Close()
+	t.mu.Unlock()
+
+	return err
+}
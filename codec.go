@@ -0,0 +1,94 @@
+package tinylfu
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Codec encodes the entries written by Save and decodes them for Load.
+type Codec interface {
+	Encode(w io.Writer, entries []SavedEntry) error
+	Decode(r io.Reader) ([]SavedEntry, error)
+}
+
+// GobCodec encodes entries with encoding/gob. It is the default codec. Values
+// are encoded as interface values, so types other than the basic ones must be
+// registered with gob.Register, and they decode to the same types.
+type GobCodec struct{}
+
+func (GobCodec) Encode(w io.Writer, entries []SavedEntry) error {
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+func (GobCodec) Decode(r io.Reader) ([]SavedEntry, error) {
+	var entries []SavedEntry
+	err := gob.NewDecoder(r).Decode(&entries)
+	return entries, err
+}
+
+// JSONCodec encodes entries as a JSON array, which is readable and can be
+// produced and consumed outside Go. Values must be JSON serializable, and they
+// decode to the generic JSON types: numbers come back as float64, []byte as
+// its base64 string and structs as map[string]interface{}. It suits caches of
+// strings and numbers; use GobCodec to keep other value types.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(w io.Writer, entries []SavedEntry) error {
+	return json.NewEncoder(w).Encode(entries)
+}
+
+func (JSONCodec) Decode(r io.Reader) ([]SavedEntry, error) {
+	var entries []SavedEntry
+	err := json.NewDecoder(r).Decode(&entries)
+	return entries, err
+}
+
+// WithCodec sets the codec used by Save and Load. The default is GobCodec.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+func (t *T) codec() Codec {
+	if t.opts.codec == nil {
+		return GobCodec{}
+	}
+	return t.opts.codec
+}
+
+// Save writes the live entries of the cache and their frequency estimates to
+// w with the codec set by WithCodec. OnEvict callbacks can't be saved and are
+// dropped.
+func (t *T) Save(w io.Writer) error {
+	return t.codec().Encode(w, t.savedEntries())
+}
+
+// Load reads entries written by Save with the same codec and stores them in
+// t, which is usually a cache just created with New. Like UnmarshalBinary it
+// skips entries that have expired and applies the normal admission and
+// capacity rules to the rest.
+func (t *T) Load(r io.Reader) error {
+	entries, err := t.codec().Decode(r)
+	if err != nil {
+		return err
+	}
+	return t.restore(entries)
+}
+
+func (t *SyncT) Save(w io.Writer) error {
+	t.rlock()
+	err := t.t.Save(w)
+	t.runlock()
+
+	return err
+}
+
+func (t *SyncT) Load(r io.Reader) error {
+	t.mu.Lock()
+	err := t.t.Load(r)
+	t.mu.Unlock()
+
+	return err
+}
@@ -0,0 +1,61 @@
+package tinylfu
+
+import (
+	"sort"
+
+	"github.com/vmihailenco/go-tinylfu/internal/list"
+)
+
+// OrphanedKeys returns the keys whose entry is in the index but not linked
+// into the window or SLRU lists, or is linked under a different key. Such
+// entries can't be evicted and aren't counted by the segments, so any result
+// means the cache is corrupted. It walks the whole cache and is meant for
+// tests and debugging.
+func (t *T) OrphanedKeys() []string {
+	linked := make(map[*list.Element]struct{}, len(t.data))
+	for _, l := range []*list.List{t.lru.ll, t.slru.one, t.slru.two} {
+		for e := l.Front(); e != nil; e = e.Next() {
+			linked[e] = struct{}{}
+		}
+	}
+
+	var orphans []string
+	for key, e := range t.data {
+		if _, ok := linked[e]; !ok || e.Value.(*Item).Key != key {
+			orphans = append(orphans, key)
+		}
+	}
+	sort.Strings(orphans)
+
+	return orphans
+}
+
+func (t *SyncT) OrphanedKeys() []string {
+	t.mu.RLock()
+	keys := t.t.OrphanedKeys()
+	t.mu.RUnlock()
+
+	return keys
+}
+
+// HashCollisionEstimate returns how many resident keys share their hash with
+// another resident key: the number of resident keys minus the number of
+// distinct hashes among them. Colliding keys share their frequency counters,
+// so a count well above zero means WithHasher was given a weak hash. With a
+// good 64-bit hash it is 0 for any realistic cache size. It walks the whole
+// cache and is meant for diagnostics.
+func (t *T) HashCollisionEstimate() int {
+	hashes := make(map[uint64]struct{}, len(t.data))
+	for _, e := range t.data {
+		hashes[e.Value.(*Item).keyh] = struct{}{}
+	}
+	return len(t.data) - len(hashes)
+}
+
+func (t *SyncT) HashCollisionEstimate() int {
+	t.mu.RLock()
+	n := t.t.HashCollisionEstimate()
+	t.mu.RUnlock()
+
+	return n
+}
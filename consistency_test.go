@@ -0,0 +1,32 @@
+package tinylfu
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestOrphanedKeys(t *testing.T) {
+	cache := New(100, 10000)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprint(i)
+		cache.Set(&Item{Key: key, Value: i})
+		cache.Get(key)
+	}
+	if orphans := cache.OrphanedKeys(); orphans != nil {
+		t.Fatalf("got orphans %v in a consistent cache", orphans)
+	}
+
+	// Unlink an entry but keep it indexed.
+	e := cache.data["7"]
+	cache.slru.one.Remove(e)
+	cache.slru.two.Remove(e)
+
+	// Index an entry under the wrong key.
+	cache.data["alias"] = cache.data["8"]
+
+	want := []string{"7", "alias"}
+	if orphans := cache.OrphanedKeys(); !reflect.DeepEqual(orphans, want) {
+		t.Fatalf("got orphans %v, wanted %v", orphans, want)
+	}
+}
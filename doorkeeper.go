@@ -2,8 +2,49 @@ package tinylfu
 
 import (
 	"math"
+	"math/bits"
 )
 
+// Doorkeeper is the first-access gate of admission: an item leaving the window
+// is only weighed against the eviction victim if the doorkeeper has seen its
+// key before. The default is a bloom filter sized from samples.
+type Doorkeeper interface {
+	// Allow records keyh and reports whether it was recorded before since
+	// the last Reset.
+	Allow(keyh uint64) bool
+	// Reset forgets all recorded keys. It is called each time the frequency
+	// sketch is aged.
+	Reset()
+}
+
+// filter is the doorkeeper as used by T. Besides the built-in doorkeeper it
+// is implemented by customDoorkeeper, which wraps a Doorkeeper.
+type filter interface {
+	allow(keyh uint64) bool
+	reset()
+	len() int
+	resetRange(start, end int)
+	density() float64
+}
+
+// customDoorkeeper adapts a Doorkeeper set with WithDoorkeeper. It can't be
+// reset in steps, so an incremental reset resets it at once in its first step,
+// and its density is unknown.
+type customDoorkeeper struct {
+	d Doorkeeper
+}
+
+func (c customDoorkeeper) allow(keyh uint64) bool { return c.d.Allow(keyh) }
+func (c customDoorkeeper) reset()                 { c.d.Reset() }
+func (c customDoorkeeper) len() int               { return 1 }
+func (c customDoorkeeper) density() float64       { return 0 }
+
+func (c customDoorkeeper) resetRange(start, end int) {
+	if start == 0 && end > 0 {
+		c.d.Reset()
+	}
+}
+
 // doorkeeper is a small bloom-filter-based cache admission policy
 type doorkeeper struct {
 	m      uint32    // size of bit vector in bits
@@ -60,6 +101,36 @@ func (d *doorkeeper) reset() {
 	}
 }
 
+// len returns the number of words in the bloom filter.
+func (d *doorkeeper) len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.filter)
+}
+
+// resetRange clears the words [start, end) of the bloom filter.
+func (d *doorkeeper) resetRange(start, end int) {
+	if d == nil {
+		return
+	}
+	for i := start; i < end; i++ {
+		d.filter[i] = 0
+	}
+}
+
+// density returns the fraction of bits set in the bloom filter
+func (d *doorkeeper) density() float64 {
+	if d == nil {
+		return 0
+	}
+	var n int
+	for _, w := range d.filter {
+		n += bits.OnesCount64(w)
+	}
+	return float64(n) / float64(d.m)
+}
+
 // Internal routines for the bit vector
 type bitvector []uint64
 
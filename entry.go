@@ -0,0 +1,58 @@
+package tinylfu
+
+import "time"
+
+// EntryInfo describes a resident entry.
+type EntryInfo struct {
+	Value     interface{}
+	ExpireAt  time.Time
+	CreatedAt time.Time
+	Version   uint64
+	Segment   Segment
+	// Frequency is the estimated access frequency of the key.
+	Frequency uint8
+	// Size is the value size, see Item.Size.
+	Size int64
+}
+
+// MGetEntries returns the EntryInfo of each live key of keys. Missing and
+// expired keys are left out of the map. Like Peek it doesn't count as an
+// access.
+func (t *T) MGetEntries(keys []string) map[string]EntryInfo {
+	entries := make(map[string]EntryInfo, len(keys))
+
+	now := t.opts.clock.Now()
+	for _, key := range keys {
+		t.flushPending(key)
+
+		val, ok := t.data[key]
+		if !ok {
+			continue
+		}
+
+		item := val.Value.(*Item)
+		if item.expired(now) {
+			continue
+		}
+
+		entries[key] = EntryInfo{
+			Value:     item.Value,
+			ExpireAt:  item.ExpireAt,
+			CreatedAt: item.CreatedAt,
+			Version:   item.Version,
+			Segment:   Segment(item.listid),
+			Frequency: t.countSketch.estimate(item.keyh),
+			Size:      item.size,
+		}
+	}
+
+	return entries
+}
+
+func (t *SyncT) MGetEntries(keys []string) map[string]EntryInfo {
+	t.rlock()
+	entries := t.t.MGetEntries(keys)
+	t.runlock()
+
+	return entries
+}
@@ -0,0 +1,66 @@
+package tinylfu
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Fingerprint returns a hash of the live entries of the cache. Per-entry
+// hashes of the key and value are combined with XOR, so two caches holding the
+// same keys and values have the same fingerprint whatever order the entries
+// were stored in or which segments they are in. Expired entries are left out.
+//
+// Strings and byte slices are hashed as is, fmt.Stringer values by their
+// String and other values by their gob encoding. Values gob can't encode fall
+// back to their fmt %v form. Values whose encoding isn't deterministic, such
+// as maps, make the fingerprint unreliable.
+func (t *T) Fingerprint() uint64 {
+	t.Flush()
+
+	var fp uint64
+	var buf bytes.Buffer
+	d := xxhash.New()
+
+	now := t.opts.clock.Now()
+	for _, e := range t.data {
+		item := e.Value.(*Item)
+		if item.expired(now) {
+			continue
+		}
+
+		d.Reset()
+		_, _ = d.WriteString(item.Key)
+		_, _ = d.Write([]byte{0})
+
+		switch v := item.Value.(type) {
+		case string:
+			_, _ = d.WriteString(v)
+		case []byte:
+			_, _ = d.Write(v)
+		case fmt.Stringer:
+			_, _ = d.WriteString(v.String())
+		default:
+			buf.Reset()
+			if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+				_, _ = fmt.Fprintf(d, "%v", v)
+			} else {
+				_, _ = d.Write(buf.Bytes())
+			}
+		}
+
+		fp ^= d.Sum64()
+	}
+
+	return fp
+}
+
+func (t *SyncT) Fingerprint() uint64 {
+	t.rlock()
+	fp := t.t.Fingerprint()
+	t.runlock()
+
+	return fp
+}
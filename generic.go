@@ -0,0 +1,65 @@
+package tinylfu
+
+import "time"
+
+// CacheItem is the Item of a Cache, with a value of type V.
+type CacheItem[V any] struct {
+	Key      string
+	Value    V
+	ExpireAt time.Time
+	OnEvict  func()
+}
+
+// Cache is a T holding values of type V, so Get returns a V without a type
+// assertion at the call site. It is built on T, with the same admission and
+// eviction, and like T it is not safe for concurrent use.
+//
+// Values are still stored in Item.Value, so Set boxes V into an interface,
+// which allocates for most types, just like T.Set; together with the Item
+// that makes two allocations per Set. Get only unboxes and doesn't allocate.
+// Cache saves the type assertions, not the allocations.
+type Cache[V any] struct {
+	t *T
+}
+
+// NewCache is like New for a cache of V values.
+func NewCache[V any](size, samples int, opts ...Option) *Cache[V] {
+	return &Cache[V]{t: New(size, samples, opts...)}
+}
+
+// Get returns the value stored under key.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	val, ok := c.t.Get(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	// The comma-ok form keeps a nil interface V from panicking.
+	v, _ := val.(V)
+	return v, true
+}
+
+// Set stores item, replacing the value of an existing key, see T.Set.
+func (c *Cache[V]) Set(item *CacheItem[V]) {
+	c.t.Set(c.item(item))
+}
+
+// Add stores item unless the key exists, see T.Add.
+func (c *Cache[V]) Add(item *CacheItem[V]) error {
+	return c.t.Add(c.item(item))
+}
+
+// Del removes key, see T.Del.
+func (c *Cache[V]) Del(key string) {
+	c.t.Del(key)
+}
+
+func (c *Cache[V]) item(item *CacheItem[V]) *Item {
+	return &Item{
+		Key:      item.Key,
+		Value:    item.Value,
+		ExpireAt: item.ExpireAt,
+		OnEvict:  item.OnEvict,
+	}
+}
@@ -0,0 +1,52 @@
+package tinylfu
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+)
+
+// hll is a HyperLogLog cardinality estimator with 4096 registers. Its
+// standard error is 1.04/sqrt(4096), about 1.6%.
+type hll struct {
+	reg [hllRegisters]uint8
+}
+
+func (h *hll) add(keyh uint64) {
+	idx := keyh >> (64 - hllPrecision)
+	// The sentinel bit bounds the rank for hashes with many trailing zeros.
+	rank := uint8(bits.LeadingZeros64(keyh<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.reg[idx] {
+		h.reg[idx] = rank
+	}
+}
+
+func (h *hll) estimate() uint64 {
+	const m = float64(hllRegisters)
+	const alpha = 0.7213 / (1 + 1.079/m)
+
+	var sum float64
+	var zeros int
+	for _, r := range h.reg {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Small range correction.
+		e = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(e + 0.5)
+}
+
+func (h *hll) reset() {
+	h.reg = [hllRegisters]uint8{}
+}
@@ -0,0 +1,193 @@
+// Package list is a doubly linked list with the API of container/list that can
+// allocate its elements in contiguous slabs and reuse removed elements.
+//
+// A list made by New behaves like container/list: every element is allocated
+// on its own, and Next and Prev of a removed element return nil. A list made
+// by NewSlab allocates its elements in slabs instead. A cache keeps a bounded
+// number of entries and moves them between lists, so elements neighbouring in
+// memory are then usually neighbours in insertion order, and a list that has
+// reached its steady size stops allocating.
+//
+// A removed element of a slab list goes back to the free list and is reused
+// by a later push, so it must not be used after Remove: its Next and Prev
+// don't reliably return nil. Code walking such a list while callbacks may
+// modify it must collect the values first.
+//
+// Only the elements are laid out contiguously; the values are still boxed in
+// an interface and point to separately allocated items.
+package list
+
+// maxSlab is the largest number of elements allocated at once.
+const maxSlab = 1024
+
+// Element is an element of a List.
+type Element struct {
+	next, prev *Element
+	list       *List
+
+	// The value stored with this element.
+	Value interface{}
+}
+
+// Next returns the next list element or nil.
+func (e *Element) Next() *Element {
+	if p := e.next; e.list != nil && p != &e.list.root {
+		return p
+	}
+	return nil
+}
+
+// Prev returns the previous list element or nil.
+func (e *Element) Prev() *Element {
+	if p := e.prev; e.list != nil && p != &e.list.root {
+		return p
+	}
+	return nil
+}
+
+// List is a doubly linked list. The zero value is an empty list ready to use.
+type List struct {
+	root Element
+	len  int
+
+	// slabs is set for lists made by NewSlab. free links the removed
+	// elements through next and slab holds the allocated elements not used
+	// yet.
+	slabs bool
+	free  *Element
+	slab  []Element
+}
+
+// New returns an initialized list that allocates each element on its own.
+func New() *List {
+	return new(List).init()
+}
+
+// NewSlab returns an initialized list that allocates its elements in slabs
+// and reuses removed elements.
+func NewSlab() *List {
+	l := New()
+	l.slabs = true
+	return l
+}
+
+func (l *List) init() *List {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	return l
+}
+
+func (l *List) lazyInit() {
+	if l.root.next == nil {
+		l.init()
+	}
+}
+
+// Len returns the number of elements of the list.
+func (l *List) Len() int { return l.len }
+
+// Front returns the first element of the list or nil.
+func (l *List) Front() *Element {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.next
+}
+
+// Back returns the last element of the list or nil.
+func (l *List) Back() *Element {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+// alloc returns an unused element, growing the slabs with the list.
+func (l *List) alloc() *Element {
+	if !l.slabs {
+		return new(Element)
+	}
+	if e := l.free; e != nil {
+		l.free = e.next
+		return e
+	}
+
+	if len(l.slab) == 0 {
+		n := l.len
+		if n < 16 {
+			n = 16
+		} else if n > maxSlab {
+			n = maxSlab
+		}
+		l.slab = make([]Element, n)
+	}
+	e := &l.slab[0]
+	l.slab = l.slab[1:]
+	return e
+}
+
+func (l *List) insert(e, at *Element) *Element {
+	e.prev = at
+	e.next = at.next
+	e.prev.next = e
+	e.next.prev = e
+	e.list = l
+	l.len++
+	return e
+}
+
+func (l *List) remove(e *Element) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev = nil
+	e.list = nil
+	l.len--
+}
+
+func (l *List) move(e, at *Element) {
+	if e == at {
+		return
+	}
+	e.prev.next = e.next
+	e.next.prev = e.prev
+
+	e.prev = at
+	e.next = at.next
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// Remove removes e from l if e is an element of l and returns e.Value. In a
+// slab list the element is reused by a later push and must not be used
+// afterwards.
+func (l *List) Remove(e *Element) interface{} {
+	v := e.Value
+	if e.list == l {
+		l.remove(e)
+		if !l.slabs {
+			return v
+		}
+		e.Value = nil
+		e.next = l.free
+		l.free = e
+	}
+	return v
+}
+
+// PushFront inserts a new element with value v at the front of the list and
+// returns it.
+func (l *List) PushFront(v interface{}) *Element {
+	l.lazyInit()
+	e := l.alloc()
+	e.Value = v
+	return l.insert(e, &l.root)
+}
+
+// MoveToFront moves e to the front of l. If e is not an element of l, the
+// list is not modified.
+func (l *List) MoveToFront(e *Element) {
+	if e.list != l || l.root.next == e {
+		return
+	}
+	l.move(e, &l.root)
+}
@@ -0,0 +1,153 @@
+package list
+
+import (
+	"container/list"
+	"testing"
+)
+
+func values(l *List) []int {
+	var vs []int
+	for e := l.Front(); e != nil; e = e.Next() {
+		vs = append(vs, e.Value.(int))
+	}
+	return vs
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestList(t *testing.T) {
+	var l List
+	if l.Front() != nil || l.Back() != nil || l.Len() != 0 {
+		t.Fatal("zero list isn't empty")
+	}
+
+	e1 := l.PushFront(1)
+	e2 := l.PushFront(2)
+	e3 := l.PushFront(3)
+	if got := values(&l); !equal(got, []int{3, 2, 1}) {
+		t.Fatalf("got %v", got)
+	}
+
+	l.MoveToFront(e1)
+	if got := values(&l); !equal(got, []int{1, 3, 2}) {
+		t.Fatalf("got %v", got)
+	}
+	if l.Back() != e2 || e2.Prev() != e3 || e2.Next() != nil {
+		t.Fatal("bad links")
+	}
+
+	if v := l.Remove(e3); v != 3 {
+		t.Fatalf("Remove returned %v", v)
+	}
+	if got := values(&l); !equal(got, []int{1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+
+	// Removing an element of another list is a no-op.
+	other := New()
+	other.Remove(e1)
+	if l.Len() != 2 {
+		t.Fatal("Remove of a foreign element modified the list")
+	}
+
+	// As with container/list a removed element is detached and not reused.
+	if e3.Next() != nil || e3.Prev() != nil {
+		t.Fatal("removed element is still linked")
+	}
+	if e := l.PushFront(4); e == e3 {
+		t.Fatal("removed element was reused")
+	}
+	if got := values(&l); !equal(got, []int{4, 1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestSlabList(t *testing.T) {
+	l := NewSlab()
+	l.PushFront(1)
+	e2 := l.PushFront(2)
+	l.PushFront(3)
+
+	if v := l.Remove(e2); v != 2 {
+		t.Fatalf("Remove returned %v", v)
+	}
+	// Removed elements are reused.
+	if e := l.PushFront(4); e != e2 {
+		t.Fatal("removed element wasn't reused")
+	}
+	if got := values(l); !equal(got, []int{4, 3, 1}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestListGrowth(t *testing.T) {
+	l := NewSlab()
+	for i := 0; i < 5000; i++ {
+		l.PushFront(i)
+	}
+	n := 0
+	for e := l.Back(); e != nil; e = e.Prev() {
+		if e.Value.(int) != n {
+			t.Fatalf("got %v at %d", e.Value, n)
+		}
+		n++
+	}
+	if n != 5000 || l.Len() != 5000 {
+		t.Fatalf("got %d elements, Len %d", n, l.Len())
+	}
+}
+
+// BenchmarkChurn runs the access pattern of a full cache segment: the back is
+// removed and a new element pushed to the front, with some elements moved to
+// the front in between, and then the list is walked from the back.
+func BenchmarkChurn(b *testing.B) {
+	const size = 100000
+
+	b.Run("container/list", func(b *testing.B) {
+		l := list.New()
+		elems := make([]*list.Element, 0, size)
+		for i := 0; i < size; i++ {
+			elems = append(elems, l.PushFront(i))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.Remove(l.Back())
+			elems[i%size] = l.PushFront(i)
+			l.MoveToFront(elems[(i*7919)%size])
+			if i%size == 0 {
+				for e := l.Back(); e != nil; e = e.Prev() {
+				}
+			}
+		}
+	})
+
+	b.Run("slab", func(b *testing.B) {
+		l := NewSlab()
+		elems := make([]*Element, 0, size)
+		for i := 0; i < size; i++ {
+			elems = append(elems, l.PushFront(i))
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.Remove(l.Back())
+			elems[i%size] = l.PushFront(i)
+			l.MoveToFront(elems[(i*7919)%size])
+			if i%size == 0 {
+				for e := l.Back(); e != nil; e = e.Prev() {
+				}
+			}
+		}
+	})
+}
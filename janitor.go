@@ -0,0 +1,95 @@
+package tinylfu
+
+import (
+	"sync"
+	"time"
+)
+
+// WithJanitor makes a SyncT remove expired entries every interval from a
+// background goroutine, so that entries nobody reads again don't keep their
+// slots and memory. Each sweep holds the write lock while it scans the whole
+// cache. Stop or Close ends the goroutine. It has no effect on a T, which
+// can call RemoveExpired itself.
+func WithJanitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitor = interval
+	}
+}
+
+// RemoveExpired removes all expired entries, firing their callbacks as a Get
+// of each would, and returns how many were removed.
+func (t *T) RemoveExpired() int {
+	now := t.opts.clock.Now()
+
+	var expired []*Item
+	for _, e := range t.data {
+		if item := e.Value.(*Item); item.expired(now) {
+			expired = append(expired, item)
+		}
+	}
+
+	var n int
+	for _, item := range expired {
+		// A callback fired by an earlier removal may have changed the entry.
+		if e, ok := t.data[item.Key]; ok && e.Value.(*Item) == item {
+			t.expire(e)
+			n++
+		}
+	}
+	return n
+}
+
+func (t *SyncT) RemoveExpired() int {
+	t.mu.Lock()
+	n := t.t.RemoveExpired()
+	t.mu.Unlock()
+
+	return n
+}
+
+// janitor runs RemoveExpired every interval until stop is closed.
+type janitor struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func startJanitor(t *SyncT, interval time.Duration) *janitor {
+	j := &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.RemoveExpired()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+
+	return j
+}
+
+// halt stops the goroutine and waits for it to return.
+func (j *janitor) halt() {
+	j.stopOnce.Do(func() { close(j.stop) })
+	<-j.done
+}
+
+// Stop ends the WithJanitor goroutine and waits for a sweep in progress to
+// finish. It is safe to call more than once and the cache can still be used
+// afterwards, without the janitor.
+func (t *SyncT) Stop() {
+	if t.janitor != nil {
+		t.janitor.halt()
+	}
+}
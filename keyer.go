@@ -0,0 +1,34 @@
+package tinylfu
+
+import "time"
+
+// Keyer is implemented by structured keys. CacheKey must be stable, i.e.
+// equal keys always return the same string, and collision free, i.e.
+// different keys never return the same string.
+type Keyer interface {
+	CacheKey() string
+}
+
+// GetKeyed is like Get for a structured key.
+func (t *T) GetKeyed(k Keyer) (interface{}, bool) {
+	return t.Get(k.CacheKey())
+}
+
+// SetKeyed is like Set for a structured key. A ttl <= 0 means no expiry.
+func (t *T) SetKeyed(k Keyer, value interface{}, ttl time.Duration) {
+	t.Set(t.keyedItem(k, value, ttl))
+}
+
+func (t *T) keyedItem(k Keyer, value interface{}, ttl time.Duration) *Item {
+	return t.ttlItem(k.CacheKey(), value, ttl)
+}
+
+func (t *SyncT) GetKeyed(k Keyer) (interface{}, bool) {
+	return t.Get(k.CacheKey())
+}
+
+func (t *SyncT) SetKeyed(k Keyer, value interface{}, ttl time.Duration) {
+	t.mu.Lock()
+	t.t.SetKeyed(k, value, ttl)
+	t.mu.Unlock()
+}
@@ -0,0 +1,118 @@
+package tinylfu
+
+import (
+	"fmt"
+	"time"
+)
+
+// GetOrSet returns the value of key like Get. On a miss it calls load and
+// stores the value it returns with the expiry it returns, where a zero time
+// never expires, before returning it. If load fails nothing is stored and its
+// error is returned.
+func (t *T) GetOrSet(key string, load func() (interface{}, time.Time, error)) (interface{}, error) {
+	value, _, err := t.GetOrCompute(key, load)
+	return value, err
+}
+
+// GetOrCompute is GetOrSet that also reports whether load ran, which is the
+// case on every miss, including when load fails.
+func (t *T) GetOrCompute(key string, load func() (interface{}, time.Time, error)) (value interface{}, loaded bool, err error) {
+	if value, ok := t.Get(key); ok {
+		return value, false, nil
+	}
+
+	value, expireAt, err := load()
+	if err != nil {
+		return nil, true, err
+	}
+
+	t.Set(&Item{Key: key, Value: value, ExpireAt: expireAt})
+	return value, true, nil
+}
+
+// GetOrSet returns the value of key, loading it on a miss, see T.GetOrSet.
+// load runs while holding the write lock, so a slow loader blocks every other
+// operation on the cache and load must not use the cache itself.
+func (t *SyncT) GetOrSet(key string, load func() (interface{}, time.Time, error)) (interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.t.GetOrSet(key, load)
+}
+
+// GetOrCompute is GetOrSet that also reports whether load ran, see
+// T.GetOrCompute. Like GetOrSet it holds the write lock while load runs.
+func (t *SyncT) GetOrCompute(key string, load func() (interface{}, time.Time, error)) (interface{}, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.t.GetOrCompute(key, load)
+}
+
+// flight is a load in progress started by SyncT.Do.
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Do returns the value of key like Get. On a miss it calls fn and stores the
+// value it returns with the expiry it returns, like GetOrSet, but fn runs
+// without holding the lock, and concurrent calls of Do for the same key wait
+// for the running fn and share its result instead of calling fn again. If fn
+// fails nothing is stored and every waiting caller gets its error; the next
+// call of Do calls fn again. If fn panics the panic goes on in the caller
+// whose fn it was, and the others get an error.
+func (t *SyncT) Do(key string, fn func() (interface{}, time.Time, error)) (interface{}, error) {
+	if value, ok := t.Get(key); ok {
+		return value, nil
+	}
+
+	t.flightMu.Lock()
+	if f, ok := t.flights[key]; ok {
+		t.flightMu.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	if t.flights == nil {
+		t.flights = make(map[string]*flight)
+	}
+	t.flights[key] = f
+	t.flightMu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			f.err = fmt.Errorf("load of key %q panicked: %v", key, r)
+			t.land(key, f)
+			panic(r)
+		}
+		t.land(key, f)
+	}()
+
+	// A load that finished since the Get above stored the value before
+	// landing.
+	if value, ok := t.Peek(key); ok {
+		f.value = value
+		return value, nil
+	}
+
+	value, expireAt, err := fn()
+	if err != nil {
+		f.err = err
+		return nil, err
+	}
+
+	t.Set(&Item{Key: key, Value: value, ExpireAt: expireAt})
+	f.value = value
+	return value, nil
+}
+
+// land ends the flight of key and wakes up its waiters.
+func (t *SyncT) land(key string, f *flight) {
+	t.flightMu.Lock()
+	delete(t.flights, key)
+	t.flightMu.Unlock()
+
+	close(f.done)
+}
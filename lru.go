@@ -1,19 +1,23 @@
 package tinylfu
 
-import "container/list"
+import "github.com/vmihailenco/go-tinylfu/internal/list"
 
 // Cache is an LRU cache.  It is not safe for concurrent access.
 type lruCache struct {
 	data map[string]*list.Element
 	cap  int
 	ll   *list.List
+
+	// reuses and allocs count how often add reused the tail element or
+	// allocated a new one
+	reuses, allocs uint64
 }
 
-func newLRU(cap int, data map[string]*list.Element) *lruCache {
+func newLRU(cap int, data map[string]*list.Element, ll *list.List) *lruCache {
 	return &lruCache{
 		data: data,
 		cap:  cap,
-		ll:   list.New(),
+		ll:   ll,
 	}
 }
 
@@ -25,11 +29,13 @@ func (lru *lruCache) get(v *list.Element) {
 // Set sets a value in the cache
 func (lru *lruCache) add(newItem *Item) (_ *Item, evicted bool) {
 	if lru.ll.Len() < lru.cap {
+		lru.allocs++
 		lru.data[newItem.Key] = lru.ll.PushFront(newItem)
 		return nil, false
 	}
 
 	// reuse the tail item
+	lru.reuses++
 	val := lru.ll.Back()
 	item := val.Value.(*Item)
 
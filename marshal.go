@@ -0,0 +1,145 @@
+package tinylfu
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/go-tinylfu/internal/list"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*T)(nil)
+	_ encoding.BinaryUnmarshaler = (*T)(nil)
+)
+
+// binaryVersion is the version of the MarshalBinary format.
+const binaryVersion = 1
+
+type binaryCache struct {
+	Version int
+	Entries []SavedEntry
+}
+
+// SavedEntry is an entry as encoded by MarshalBinary and Save, with the
+// frequency estimate of its key.
+type SavedEntry struct {
+	Key          string
+	Value        interface{}
+	ExpireAt     time.Time
+	SourceTime   time.Time
+	ProtectUntil time.Time
+	Frequency    uint8
+	Tags         []string
+	SlidingTTL   time.Duration
+}
+
+// MarshalBinary encodes the live entries of the cache together with their
+// frequency estimates. Values are gob encoded as interface values, so types
+// other than the basic ones must be registered with gob.Register. OnEvict
+// callbacks can't be encoded and are dropped.
+func (t *T) MarshalBinary() ([]byte, error) {
+	c := binaryCache{
+		Version: binaryVersion,
+		Entries: t.savedEntries(),
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary stores the entries encoded by MarshalBinary in t, which is
+// usually a cache just created with New. Entries that have expired since they
+// were encoded are skipped. The rest go through the normal admission and
+// capacity rules, so a smaller cache keeps only part of them.
+func (t *T) UnmarshalBinary(data []byte) error {
+	var c binaryCache
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return err
+	}
+	if c.Version != binaryVersion {
+		return fmt.Errorf("unsupported binary format version %d", c.Version)
+	}
+
+	return t.restore(c.Entries)
+}
+
+// savedEntries returns the live entries from the least to the most valuable,
+// like Merge.
+func (t *T) savedEntries() []SavedEntry {
+	t.Flush()
+
+	entries := make([]SavedEntry, 0, len(t.data))
+
+	now := t.opts.clock.Now()
+	for _, l := range []*list.List{t.slru.two, t.slru.one, t.lru.ll} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			item := e.Value.(*Item)
+			if item.expired(now) {
+				continue
+			}
+			entries = append(entries, SavedEntry{
+				Key:          item.Key,
+				Value:        item.Value,
+				ExpireAt:     item.ExpireAt,
+				SourceTime:   item.SourceTime,
+				ProtectUntil: item.ProtectUntil,
+				Frequency:    t.countSketch.estimate(item.keyh),
+				Tags:         item.Tags,
+				SlidingTTL:   item.SlidingTTL,
+			})
+		}
+	}
+
+	return entries
+}
+
+// restore stores saved entries, skipping the expired ones.
+func (t *T) restore(entries []SavedEntry) error {
+	now := t.opts.clock.Now()
+	for _, e := range entries {
+		item := &Item{
+			Key:          e.Key,
+			Value:        e.Value,
+			ExpireAt:     e.ExpireAt,
+			SourceTime:   e.SourceTime,
+			ProtectUntil: e.ProtectUntil,
+			Tags:         e.Tags,
+			SlidingTTL:   e.SlidingTTL,
+		}
+		if item.expired(now) {
+			continue
+		}
+
+		keyh := t.hash(e.Key)
+		for n := e.Frequency; n > 0; n-- {
+			t.countSketch.add(keyh)
+		}
+		if err := t.set(item, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *SyncT) MarshalBinary() ([]byte, error) {
+	t.rlock()
+	data, err := t.t.MarshalBinary()
+	t.runlock()
+
+	return data, err
+}
+
+func (t *SyncT) UnmarshalBinary(data []byte) error {
+	t.mu.Lock()
+	err := t.t.UnmarshalBinary(data)
+	t.mu.Unlock()
+
+	return err
+}
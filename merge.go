@@ -0,0 +1,119 @@
+package tinylfu
+
+import "github.com/vmihailenco/go-tinylfu/internal/list"
+
+// MergePolicy decides which value wins when Merge finds a key in both caches.
+type MergePolicy int
+
+const (
+	// MergeKeepExisting keeps the receiver's entry.
+	MergeKeepExisting MergePolicy = iota
+	// MergePreferIncoming replaces the receiver's entry with the other one.
+	MergePreferIncoming
+	// MergePreferNewer keeps the entry with the later SourceTime. Entries
+	// without SourceTime are older than any entry with one, and ties keep
+	// the receiver's entry.
+	MergePreferNewer
+)
+
+// Merge inserts the live entries of other into t. Entries go through the
+// normal admission and capacity rules, so they may not all be kept. Entries
+// are inserted from the least to the most recently used so the recency order
+// of other is roughly preserved.
+//
+// If carryFrequency is set, the frequency estimate other has for each merged
+// key is added to t's sketch before the entry is inserted, which helps hot
+// entries win admission.
+//
+// Merged entries keep their OnEvict callbacks and ProtectUntil. When an entry
+// of t is replaced, its OnEvictReason callback fires with ReasonReplaced as it
+// would on Set, and the incoming callbacks take over. other is not modified
+// apart from applying its pending coalesced writes, so if it keeps being used
+// both caches may fire the same callback.
+func (t *T) Merge(other *T, policy MergePolicy, carryFrequency bool) {
+	if other == t {
+		return
+	}
+	other.Flush()
+
+	// The items are copied first: a callback fired by t may modify other,
+	// which reuses evicted items, and removed elements with WithSlabLists.
+	now := other.opts.clock.Now()
+	items := make([]Item, 0, len(other.data))
+	for _, l := range []*list.List{other.slru.two, other.slru.one, other.lru.ll} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			if item := e.Value.(*Item); !item.expired(now) {
+				items = append(items, *item)
+			}
+		}
+	}
+	for i := range items {
+		t.merge(&items[i], other, policy, carryFrequency)
+	}
+}
+
+func (t *T) merge(item *Item, other *T, policy MergePolicy, carryFrequency bool) {
+	if carryFrequency {
+		// The caches may hash keys differently, see WithHasher.
+		keyh := t.hash(item.Key)
+		for n := other.countSketch.estimate(item.keyh); n > 0; n-- {
+			t.countSketch.add(keyh)
+		}
+	}
+
+	var existing *Item
+	if e, ok := t.data[item.Key]; ok {
+		existing = e.Value.(*Item)
+		switch policy {
+		case MergeKeepExisting:
+			return
+		case MergePreferNewer:
+			if !item.SourceTime.After(existing.SourceTime) {
+				return
+			}
+		}
+	}
+
+	err := t.set(&Item{
+		Key:           item.Key,
+		Value:         item.Value,
+		ExpireAt:      item.ExpireAt,
+		OnEvict:       item.OnEvict,
+		OnEvictReason: item.OnEvictReason,
+		SourceTime:    item.SourceTime,
+		ProtectUntil:  item.ProtectUntil,
+		Tags:          item.Tags,
+		SlidingTTL:    item.SlidingTTL,
+	}, false)
+	if err != nil || existing == nil {
+		return
+	}
+
+	// set replaced the value and fired the callback of the existing entry;
+	// from now on the entry belongs to the incoming item. The promotion of
+	// set may have swapped the entry into another Item, so existing is
+	// stale and the entry is looked up again.
+	if e, ok := t.data[item.Key]; ok {
+		entry := e.Value.(*Item)
+		entry.ExpireAt = item.ExpireAt
+		entry.OnEvict = item.OnEvict
+		entry.OnEvictReason = item.OnEvictReason
+		entry.SourceTime = item.SourceTime
+		entry.ProtectUntil = item.ProtectUntil
+	}
+}
+
+// Merge inserts the live entries of other into t, see T.Merge. It holds the
+// write lock of t and the read lock of other, so two caches must not be
+// merged into each other concurrently.
+func (t *SyncT) Merge(other *SyncT, policy MergePolicy, carryFrequency bool) {
+	if other == t {
+		return
+	}
+
+	t.mu.Lock()
+	other.rlock()
+	t.t.Merge(other.t, policy, carryFrequency)
+	other.runlock()
+	t.mu.Unlock()
+}
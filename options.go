@@ -0,0 +1,539 @@
+package tinylfu
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/go-tinylfu/internal/list"
+)
+
+// Option configures a cache created by New or NewSync.
+type Option func(*options)
+
+type options struct {
+	resetStep   int
+	expiryAware bool
+	policy      EvictionPolicy
+	clock       Clock
+	onExpire    func(item *Item)
+	maxAge      time.Duration
+	defaultTTL  time.Duration
+	ttlJitter   time.Duration
+	slidingTTL  time.Duration
+	onEvict     func(item *Item)
+	onFull      func()
+	onDrain     func()
+	enforceType bool
+	sampleRate  float64
+	sampler     func(key string, hit bool)
+	onHit       func(key string)
+	onMiss      func(key string)
+	onError     func(err error)
+	onOverwrite func(key string, old, new interface{})
+
+	strictBatches    bool
+	extendFromExpiry bool
+
+	timeCallbacks    bool
+	recoverCallbacks bool
+	callbackMode     CallbackMode
+	tracer           Tracer
+	scanGuard        bool
+	keyEstimates     bool
+	resetGrace       float64
+	doorkeeper       Doorkeeper
+	bypassBelow      int
+	admission        AdmissionPolicy
+
+	maxBytes      int64
+	weighCost     bool
+	maxValueBytes int64
+	minEntries    int
+	sizer         func(value interface{}) int64
+	veto          func(item *Item) bool
+
+	coalesceWindow time.Duration
+	coalesceSink   func(item *Item)
+
+	codec     Codec
+	janitor   time.Duration
+	hasher    func(key string) uint64
+	slabLists bool
+
+	// windowPct, protectedRatio, doorkeeperFP and the sketch dimensions
+	// are 0 for the defaults.
+	windowPct      float64
+	protectedRatio float64
+	doorkeeperFP   float64
+	sketchWidth    int
+	sketchDepth    int
+
+	// err is the first invalid option value.
+	err error
+}
+
+// WithIncrementalReset spreads the periodic aging of the frequency sketch and
+// the doorkeeper across subsequent Gets instead of doing it all at once.
+//
+// By default every samples-th Get halves the whole count-min sketch and clears
+// the whole doorkeeper synchronously, so that single Get pays an
+// O(width*depth) cost. With this option the reset advances by step bytes of
+// sketch counters and step words of doorkeeper bits on each Get until it
+// completes, which bounds the extra work any one Get does. While a reset is in
+// progress some counters are already halved and others are not; the estimates
+// are approximate anyway and the skew only lasts until the reset finishes.
+// A reset that is still running when the next one is due is completed first.
+//
+// A step <= 0 keeps the default synchronous reset.
+func WithIncrementalReset(step int) Option {
+	return func(o *options) {
+		o.resetStep = step
+	}
+}
+
+// expiryAwareCandidates is the number of least recently used entries
+// considered by WithExpiryAwareEviction.
+const expiryAwareCandidates = 4
+
+// WithExpiryAwareEviction makes eviction prefer entries that are about to
+// expire anyway. Among the least recently used entries of the probation segment
+// that have the same estimated frequency as the next victim, the one with the
+// nearest ExpireAt is evicted instead. It only breaks ties: an entry is never
+// evicted ahead of a less frequently used one because of its TTL.
+func WithExpiryAwareEviction() Option {
+	return func(o *options) {
+		o.expiryAware = true
+	}
+}
+
+// WithClock sets the clock used for expiry and entry ages. The default is the
+// system clock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithOnExpire sets a callback fired once for every entry that is removed
+// because its ExpireAt passed, after the item's own OnEvict. Use
+// WithCallbackMode to fire only one of them.
+func WithOnExpire(fn func(item *Item)) Option {
+	return func(o *options) {
+		o.onExpire = fn
+	}
+}
+
+// WithMaxAge caps the age of every entry at d: once d has passed since an
+// entry was inserted it is treated exactly like an entry whose ExpireAt
+// passed, whatever its ExpireAt. The stricter of the two wins, so ExpireAt can
+// only shorten the life of an entry. Replacing the value with Set doesn't
+// restart the age.
+func WithMaxAge(d time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = d
+	}
+}
+
+// WithDefaultTTL gives every item stored without ExpireAt an ExpireAt of ttl
+// from the time it is stored. An ExpireAt set on the item always wins.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = ttl
+	}
+}
+
+// WithTTLJitter delays the expiry of every item stored with an ExpireAt, given
+// or from WithDefaultTTL, by a random duration in [0, maxJitter), so that
+// entries stored together with the same TTL don't all expire and get reloaded
+// at once. Items without an expiry are left alone.
+func WithTTLJitter(maxJitter time.Duration) Option {
+	return func(o *options) {
+		o.ttlJitter = maxJitter
+	}
+}
+
+// WithSlidingTTL gives every item without its own Item.SlidingTTL a sliding
+// TTL of d: each Get hit moves its ExpireAt to d from the time of the hit, so
+// entries stay as long as they are read at least every d. WithMaxAge still
+// bounds the life of an entry however often it is read.
+func WithSlidingTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.slidingTTL = d
+	}
+}
+
+// WithExtendFromExpiry makes GetAndExtend add the extension to the current
+// ExpireAt of the entry instead of to the current time, so that repeated
+// extensions accumulate.
+func WithExtendFromExpiry() Option {
+	return func(o *options) {
+		o.extendFromExpiry = true
+	}
+}
+
+// WithTypeEnforcement restricts the cache to a single value type: the concrete
+// type of the first value stored is recorded and values of any other type are
+// rejected with ErrInvalidItem. Values rejected for another reason, such as
+// WithMaxValueBytes or the byte budget, don't count as stored.
+func WithTypeEnforcement() Option {
+	return func(o *options) {
+		o.enforceType = true
+	}
+}
+
+// WithAccessSampler calls fn for a random fraction rate of the Gets with the
+// key and whether it was a hit. The sampling decision is a single step of a
+// xorshift generator, so a low rate adds next to nothing to Get. fn is called
+// synchronously and must not use the cache.
+func WithAccessSampler(rate float64, fn func(key string, hit bool)) Option {
+	return func(o *options) {
+		o.sampleRate = rate
+		o.sampler = fn
+	}
+}
+
+// WithOnHit sets a callback fired with the key of every Get that finds a live
+// entry, and of every Set of a key already in the cache, which counts as an
+// access although Stats don't count it as a hit. Like the other callbacks it
+// is called synchronously, under the lock of a SyncT, and must not use the
+// cache.
+func WithOnHit(fn func(key string)) Option {
+	return func(o *options) {
+		o.onHit = fn
+	}
+}
+
+// WithOnMiss sets a callback fired with the key of every Get that finds no
+// live entry, after an expired one was removed. Like WithOnHit it must not use
+// the cache.
+func WithOnMiss(fn func(key string)) Option {
+	return func(o *options) {
+		o.onMiss = fn
+	}
+}
+
+// WithOnError sets a callback for problems the cache can work around but that
+// likely mean it is misconfigured, such as a size too small to split into
+// segments.
+func WithOnError(fn func(err error)) Option {
+	return func(o *options) {
+		o.onError = fn
+	}
+}
+
+// WithSetWarnsOnOverwrite calls fn whenever Set replaces the value of a
+// resident key, with the old and new values. It is meant to catch a Set that
+// should have been an Add in tests and is off by default. fn is called
+// synchronously and must not use the cache.
+func WithSetWarnsOnOverwrite(fn func(key string, old, new interface{})) Option {
+	return func(o *options) {
+		o.onOverwrite = fn
+	}
+}
+
+// WithStrictBatches makes MSet reject a batch that contains a key more than
+// once with ErrDuplicateKey instead of keeping its last occurrence.
+func WithStrictBatches() Option {
+	return func(o *options) {
+		o.strictBatches = true
+	}
+}
+
+// WithWriteCoalescing buffers Sets for up to window so that a key written
+// repeatedly is only stored once, with its latest value. Each stored write is
+// then passed to sink, e.g. to write it through to a backing store.
+//
+// The buffer is flushed by the next cache operation after the window of a key
+// closes, so a busy cache serves values at most window old; an idle cache
+// holds them until Flush or Close. A Get of a pending key flushes it first and
+// returns the latest value, and Del discards it. Other operations on a key,
+// such as Peek, GetResult, Touch or CompareAndSwapVersion, apply its pending
+// write first too, GetAndDelete discards it like Del, and operations over the
+// whole cache such as Range or Snapshot apply all pending writes, so none of
+// them sees a stale value. A SyncT then takes the write lock for reads.
+func WithWriteCoalescing(window time.Duration, sink func(item *Item)) Option {
+	return func(o *options) {
+		o.coalesceWindow = window
+		o.coalesceSink = sink
+	}
+}
+
+// WithMaxBytes limits the total size of the cached values to n bytes in
+// addition to the entry count limit. Strings and byte slices are measured by
+// their length and other values by the WithSizer function. Entries are
+// evicted from the least valuable end of the probation, protected and window
+// segments, in that order, until the values fit. An item that does not fit
+// even after evicting everything else evictable is rejected and its OnEvict
+// fires.
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithMaxCost limits the total Cost of the resident entries to n, for values
+// whose weight isn't their length in bytes. It is the same budget as
+// WithMaxBytes, with entries without a Cost weighed by their size, and
+// additionally makes admission weigh frequency against cost: a candidate
+// leaving the window wins over the victim only if it has a higher frequency
+// per unit of cost, so a large entry needs to be proportionally more popular
+// to displace a small one. The entry count passed to New still applies, so
+// make it large enough not to be the binding limit.
+func WithMaxCost(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+		o.weighCost = true
+	}
+}
+
+// WithSizer sets the function measuring values other than strings and byte
+// slices for WithMaxBytes. Without it such values count as zero bytes.
+func WithSizer(fn func(value interface{}) int64) Option {
+	return func(o *options) {
+		o.sizer = fn
+	}
+}
+
+// WithMaxValueBytes rejects values larger than n bytes with ErrInvalidItem so a
+// single value can't take over the cache. Values are measured as for
+// WithMaxBytes. The default is no limit.
+func WithMaxValueBytes(n int64) Option {
+	return func(o *options) {
+		o.maxValueBytes = n
+	}
+}
+
+// WithMinEntries keeps the byte budget of WithMaxBytes from evicting the cache
+// below n entries. An item that could only be admitted by evicting past that
+// floor is rejected instead, firing its OnEvict, so one huge value can't flush
+// the whole working set. Replacing the value of a resident key never rejects
+// it, so the floor may leave the cache over budget.
+func WithMinEntries(n int) Option {
+	return func(o *options) {
+		o.minEntries = n
+	}
+}
+
+// maxEvictionVetoes is the number of evictions WithEvictionVeto may veto
+// while storing one item.
+const maxEvictionVetoes = 16
+
+// WithEvictionVeto sets a function consulted before an entry is evicted for
+// capacity. If it returns true the entry is kept and the next candidate is
+// considered instead. To keep a veto-happy function from stalling admission,
+// only the first 16 vetoes while storing an item are honoured; after that the
+// function is no longer consulted for that item. fn must not use the cache.
+func WithEvictionVeto(fn func(item *Item) bool) Option {
+	return func(o *options) {
+		o.veto = fn
+	}
+}
+
+// WithCallbackTiming records the number of OnEvict, WithOnEvict and
+// WithOnExpire callbacks and the time spent in them, as reported by Stats.
+// Slow callbacks run with the cache locked and delay every other operation.
+func WithCallbackTiming() Option {
+	return func(o *options) {
+		o.timeCallbacks = true
+	}
+}
+
+// WithTracer reports admissions, evictions and expirations to tracer. Every
+// event allocates its attributes, so tracing adds overhead to each of them.
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithScanGuard makes admission stricter while the cache is being scanned,
+// so a burst of one-off keys can't push out the working set before the
+// frequency sketch catches up. See scanGuard for the heuristic.
+func WithScanGuard() Option {
+	return func(o *options) {
+		o.scanGuard = true
+	}
+}
+
+// WithKeyEstimates keeps the cardinality estimates behind DistinctKeysSeen and
+// WorkingSetEstimate. They cost two HyperLogLog updates and an extra sketch
+// estimate per access and 8KB per cache, so they are off by default.
+func WithKeyEstimates() Option {
+	return func(o *options) {
+		o.keyEstimates = true
+	}
+}
+
+// WithDoorkeeper replaces the built-in bloom filter doorkeeper, e.g. to try a
+// counting or blocked bloom filter. DoorkeeperDensity reports 0 for a custom
+// doorkeeper. d is called with the cache locked and must not use it.
+func WithDoorkeeper(d Doorkeeper) Option {
+	return func(o *options) {
+		o.doorkeeper = d
+	}
+}
+
+// WithAdmissionBypass turns off admission for caches smaller than size
+// entries: every item leaving the window replaces the victim, which makes the
+// cache a plain segmented LRU. A small cache gets a small frequency sketch,
+// whose 4-bit counters saturate and collide so quickly that its estimates
+// are mostly noise, and the doorkeeper rejects every key the first time it
+// leaves the window even though a small cache rarely holds a key long enough
+// to see it again. Below a few hundred entries recency is usually the better
+// signal, so a size of around 500 is a reasonable start.
+func WithAdmissionBypass(size int) Option {
+	return func(o *options) {
+		o.bypassBelow = size
+	}
+}
+
+// WithResetGrace eases admission for the first fraction*samples Gets of every
+// epoch, including the first one. Right after the periodic reset the
+// frequency estimates are halved and the doorkeeper is empty, so admission
+// rejects most candidates on little evidence. During the grace period the
+// doorkeeper is still fed but not consulted, and a candidate that ties with
+// the victim is admitted instead of rejected. A fraction of about 0.05 is
+// usually enough for the sketch to recover. A fraction <= 0 disables the grace.
+func WithResetGrace(fraction float64) Option {
+	return func(o *options) {
+		o.resetGrace = fraction
+	}
+}
+
+// EvictionPolicy orders the entries of the window segment.
+type EvictionPolicy int
+
+const (
+	// PolicyLRU moves window entries to the front on every hit, so the least
+	// recently used one leaves the window first. It is the default.
+	PolicyLRU EvictionPolicy = iota
+	// PolicyFIFO leaves window entries in insertion order, so the entry
+	// created first leaves the window first however often it is read.
+	PolicyFIFO
+)
+
+// WithEvictionPolicy sets how the window segment orders its entries. It only
+// affects the window: entries leaving it still go through the frequency based
+// admission, and the probation and protected segments stay LRU ordered so
+// that hits keep promoting entries to the protected segment. FIFO suits
+// append-mostly data such as logs and event windows, where recency of
+// creation predicts reuse better than recency of access.
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// ErrInvalidOption is returned by NewWithOptions and NewChecked for an option
+// value out of its valid range.
+var ErrInvalidOption = errors.New("invalid option")
+
+// invalid records the first option value out of its range. The option is then
+// ignored and the default is used.
+func (o *options) invalid(format string, args ...interface{}) {
+	if o.err == nil {
+		o.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidOption}, args...)...)
+	}
+}
+
+// WithWindowPercent sets the share of the capacity given to the window
+// segment, in percent. It must be in (0, 100) and defaults to 1. A larger
+// window favours recency over frequency, which helps bursty workloads.
+func WithWindowPercent(pct float64) Option {
+	return func(o *options) {
+		if !(pct > 0 && pct < 100) {
+			o.invalid("window percent %v is not in (0, 100)", pct)
+			return
+		}
+		o.windowPct = pct
+	}
+}
+
+// WithSLRUProtectedRatio sets the share of the main segment given to the
+// protected segment, the rest going to probation. It must be in (0, 1) and
+// defaults to 0.8.
+func WithSLRUProtectedRatio(ratio float64) Option {
+	return func(o *options) {
+		if !(ratio > 0 && ratio < 1) {
+			o.invalid("protected ratio %v is not in (0, 1)", ratio)
+			return
+		}
+		o.protectedRatio = ratio
+	}
+}
+
+// WithDoorkeeperFalsePositive sets the false positive rate the built-in
+// doorkeeper is sized for. It must be in (0, 1) and defaults to 0.01. A lower
+// rate takes more memory. It has no effect with WithDoorkeeper.
+func WithDoorkeeperFalsePositive(rate float64) Option {
+	return func(o *options) {
+		if !(rate > 0 && rate < 1) {
+			o.invalid("doorkeeper false positive rate %v is not in (0, 1)", rate)
+			return
+		}
+		o.doorkeeperFP = rate
+	}
+}
+
+// WithHasher replaces xxhash.Sum64String as the hash of keys for the frequency
+// sketch, the doorkeeper and the distinct key estimates, e.g. with a keyed hash
+// so that clients choosing the keys can't force collisions. The sketch and the
+// doorkeeper index by both halves of the hash, so all 64 bits must be well
+// distributed.
+func WithHasher(fn func(key string) uint64) Option {
+	return func(o *options) {
+		o.hasher = fn
+	}
+}
+
+// WithSlabLists allocates the elements of the window and segment lists in
+// contiguous slabs and reuses the elements of removed entries, instead of
+// allocating an element per insert as container/list does. A warm cache then
+// stops allocating list elements and scans from the back of a list touch
+// fewer cache lines. Items and values are still allocated on their own.
+func WithSlabLists() Option {
+	return func(o *options) {
+		o.slabLists = true
+	}
+}
+
+// newList returns a list for the window or a segment, see WithSlabLists.
+func (o *options) newList() *list.List {
+	if o.slabLists {
+		return list.NewSlab()
+	}
+	return list.New()
+}
+
+// WithSketchWidth sets the number of counters in each row of the frequency
+// sketch, rounded up to a power of two. It must be positive and defaults to
+// the cache size. Counters take 4 bits, so the sketch takes width*depth/2
+// bytes. A wider sketch makes fewer keys share counters, which keeps the
+// estimates of rare keys from being inflated by popular ones; a narrower one
+// saves memory in a large cache with a small working set. The width is kept
+// by Resize.
+func WithSketchWidth(width int) Option {
+	return func(o *options) {
+		if width < 1 {
+			o.invalid("sketch width %d is not positive", width)
+			return
+		}
+		o.sketchWidth = width
+	}
+}
+
+// WithSketchDepth sets the number of rows of the frequency sketch. It must be
+// positive and defaults to 4. Estimates take the smallest counter of a key
+// over all rows, so more rows make an overestimate less likely at the cost of
+// memory, see WithSketchWidth, and of one more counter update and read per
+// access and per admission.
+func WithSketchDepth(depth int) Option {
+	return func(o *options) {
+		if depth < 1 {
+			o.invalid("sketch depth %d is not positive", depth)
+			return
+		}
+		o.sketchDepth = depth
+	}
+}
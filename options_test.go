@@ -0,0 +1,59 @@
+package tinylfu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	def, err := NewWithOptions(1000, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := New(1000, 100000)
+	if def.lru.cap != old.lru.cap || def.slru.onecap != old.slru.onecap || def.slru.twocap != old.slru.twocap {
+		t.Fatalf("defaults differ from New: %d/%d/%d", def.lru.cap, def.slru.onecap, def.slru.twocap)
+	}
+	if def.bouncer.(*doorkeeper).m != old.bouncer.(*doorkeeper).m {
+		t.Fatal("default doorkeeper differs from New")
+	}
+
+	c, err := NewWithOptions(1000, 100000,
+		WithWindowPercent(10),
+		WithSLRUProtectedRatio(0.5),
+		WithDoorkeeperFalsePositive(0.0001),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.lru.cap != 100 {
+		t.Fatalf("window is %d, wanted 100", c.lru.cap)
+	}
+	if c.slru.onecap != 450 || c.slru.twocap != 450 {
+		t.Fatalf("slru is %d/%d, wanted 450/450", c.slru.onecap, c.slru.twocap)
+	}
+	if m, defM := c.bouncer.(*doorkeeper).m, def.bouncer.(*doorkeeper).m; m <= defM {
+		t.Fatalf("doorkeeper has %d bits, wanted more than %d", m, defM)
+	}
+
+	for _, opt := range []Option{
+		WithWindowPercent(0),
+		WithWindowPercent(100),
+		WithSLRUProtectedRatio(0),
+		WithSLRUProtectedRatio(1),
+		WithDoorkeeperFalsePositive(0),
+		WithDoorkeeperFalsePositive(1),
+		WithSketchWidth(0),
+		WithSketchDepth(-1),
+	} {
+		if _, err := NewWithOptions(1000, 100000, opt); !errors.Is(err, ErrInvalidOption) {
+			t.Fatalf("got %v, wanted ErrInvalidOption", err)
+		}
+	}
+
+	// New keeps the defaults for invalid values.
+	c = New(1000, 100000, WithWindowPercent(-1))
+	if c.lru.cap != old.lru.cap {
+		t.Fatalf("window is %d, wanted %d", c.lru.cap, old.lru.cap)
+	}
+}
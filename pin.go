@@ -0,0 +1,60 @@
+package tinylfu
+
+// Pin protects a resident entry from capacity eviction until Unpin. Pinned
+// entries are skipped when choosing victims, but Del and expiry still remove
+// them. See Item.ProtectUntil for protection that ends by itself. Pin
+// returns false if the key is missing or expired.
+//
+// Pinning a large share of the capacity starves admission: new items can
+// only replace unpinned entries, and items leaving the window are rejected
+// when none are left.
+func (t *T) Pin(key string) bool {
+	return t.setPinned(key, true)
+}
+
+// Unpin makes a pinned entry evictable again. It returns false if the key is
+// missing or expired.
+func (t *T) Unpin(key string) bool {
+	return t.setPinned(key, false)
+}
+
+func (t *T) setPinned(key string, pinned bool) bool {
+	t.flushPending(key)
+
+	val, ok := t.data[key]
+	if !ok {
+		return false
+	}
+
+	item := val.Value.(*Item)
+	if item.expired(t.opts.clock.Now()) {
+		return false
+	}
+
+	if item.pinned != pinned {
+		item.pinned = pinned
+		if pinned {
+			t.pinned++
+		} else {
+			t.pinned--
+		}
+	}
+
+	return true
+}
+
+func (t *SyncT) Pin(key string) bool {
+	t.mu.Lock()
+	ok := t.t.Pin(key)
+	t.mu.Unlock()
+
+	return ok
+}
+
+func (t *SyncT) Unpin(key string) bool {
+	t.mu.Lock()
+	ok := t.t.Unpin(key)
+	t.mu.Unlock()
+
+	return ok
+}
@@ -0,0 +1,136 @@
+package tinylfu
+
+import (
+	"iter"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmihailenco/go-tinylfu/internal/list"
+)
+
+// Range calls f for each live entry of the cache, in no particular order,
+// until f returns false. Expired entries are skipped but not removed. f must
+// not modify the cache: f may Peek, but a method that adds, removes or
+// reorders entries, Get included, panics.
+func (t *T) Range(f func(key string, value interface{}, expireAt time.Time) bool) {
+	t.Flush()
+	atomic.AddInt32(&t.ranging, 1)
+	defer atomic.AddInt32(&t.ranging, -1)
+
+	now := t.opts.clock.Now()
+	for _, e := range t.data {
+		item := e.Value.(*Item)
+		if item.expired(now) {
+			continue
+		}
+		if !f(item.Key, item.Value, item.ExpireAt) {
+			return
+		}
+	}
+}
+
+// Range calls f for each live entry of the cache, see T.Range. The read lock
+// is held until Range returns, so f must not use the cache: a write from f
+// deadlocks, and so may a read once a writer is waiting. Unlike T.Range this
+// can't be caught, since the lock can't tell f from another goroutine waiting
+// its turn. Collect the keys in f and act on them after Range returns instead.
+func (t *SyncT) Range(f func(key string, value interface{}, expireAt time.Time) bool) {
+	t.rlock()
+	defer t.runlock()
+
+	t.t.Range(f)
+}
+
+// Keys returns the keys of the live entries, in no particular order.
+func (t *T) Keys() []string {
+	keys := make([]string, 0, len(t.data))
+	t.Range(func(key string, _ interface{}, _ time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (t *SyncT) Keys() []string {
+	t.rlock()
+	keys := t.t.Keys()
+	t.runlock()
+
+	return keys
+}
+
+// RangeProtected calls fn for each live entry of the protected segment, from
+// the most to the least recently used, until fn returns false. Entries move
+// in and out of the protected segment as they are accessed, so the result is
+// only a snapshot of the current hot set. fn must not modify the cache, see
+// Range.
+func (t *T) RangeProtected(fn func(key string, value interface{}) bool) {
+	t.Flush()
+	atomic.AddInt32(&t.ranging, 1)
+	defer atomic.AddInt32(&t.ranging, -1)
+
+	now := t.opts.clock.Now()
+	for e := t.slru.two.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*Item)
+		if item.expired(now) {
+			continue
+		}
+		if !fn(item.Key, item.Value) {
+			return
+		}
+	}
+}
+
+// RangeProtected calls fn for each live entry of the protected segment, see
+// T.RangeProtected. It holds the read lock, so fn must not use the cache.
+func (t *SyncT) RangeProtected(fn func(key string, value interface{}) bool) {
+	t.rlock()
+	t.t.RangeProtected(fn)
+	t.runlock()
+}
+
+// All returns an iterator over the live entries of the cache: the window,
+// probation and protected segments, each from the most to the least recently
+// used. Expired entries are skipped but not removed. The cache must not be
+// modified during the iteration, see Range.
+func (t *T) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		t.Flush()
+		atomic.AddInt32(&t.ranging, 1)
+		defer atomic.AddInt32(&t.ranging, -1)
+
+		now := t.opts.clock.Now()
+		for _, l := range []*list.List{t.lru.ll, t.slru.one, t.slru.two} {
+			for e := l.Front(); e != nil; e = e.Next() {
+				item := e.Value.(*Item)
+				if item.expired(now) {
+					continue
+				}
+				if !yield(item.Key, item.Value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// All returns an iterator over the live entries of the cache, see T.All. The
+// read lock is held from the first to the last step of the iteration, until
+// the loop ends or breaks, so the loop body must not use the cache: a write
+// deadlocks and a read may deadlock once a writer is waiting.
+func (t *SyncT) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		t.rlock()
+		defer t.runlock()
+
+		t.t.All()(yield)
+	}
+}
+
+// mustNotRange panics when called from within a Range, RangeProtected or All
+// walk: adding, removing or moving entries would corrupt it.
+func (t *T) mustNotRange() {
+	if atomic.LoadInt32(&t.ranging) > 0 {
+		panic("tinylfu: cache modified from within Range, RangeProtected or All")
+	}
+}
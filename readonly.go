@@ -0,0 +1,31 @@
+package tinylfu
+
+import "errors"
+
+// ErrReadOnly is returned by Add on a cache wrapped with ReadOnly.
+var ErrReadOnly = errors.New("read-only cache")
+
+// ReadOnly wraps cache so that components handed the wrapper can read but not
+// modify it. Get is passed through, so reads still count towards the
+// frequency and recency of entries. Add returns ErrReadOnly, while Set and Del
+// have no error to return and silently do nothing; code that needs to know
+// should use Add.
+func ReadOnly(cache LFU) LFU {
+	return readOnly{cache: cache}
+}
+
+type readOnly struct {
+	cache LFU
+}
+
+func (r readOnly) Get(key string) (interface{}, bool) {
+	return r.cache.Get(key)
+}
+
+func (r readOnly) Add(newItem *Item) error {
+	return ErrReadOnly
+}
+
+func (r readOnly) Set(newItem *Item) {}
+
+func (r readOnly) Del(key string) {}
@@ -0,0 +1,105 @@
+package tinylfu
+
+import (
+	"time"
+
+	"github.com/vmihailenco/go-tinylfu/internal/list"
+)
+
+// reservationTimeout is how long a Reservation can be committed.
+const reservationTimeout = 10 * time.Second
+
+// Reservation is the admission decision for a key made by Reserve.
+type Reservation struct {
+	key      string
+	expireAt time.Time
+}
+
+// Key returns the reserved key.
+func (r Reservation) Key() string { return r.key }
+
+// Reserve decides whether key would be admitted now, before its value is
+// computed. It returns false if it wouldn't: the cache is full and the key is
+// estimated to be used less than the entry it would have to evict. Otherwise
+// the returned Reservation lets Commit store the value without running the
+// admission again.
+//
+// A Reservation doesn't hold a slot. It must be committed within 10 seconds,
+// after which Commit rejects it so stale decisions aren't acted on; an
+// uncommitted Reservation needs no cleanup. Reserve doesn't count as an
+// access, which the preceding Get that missed already did.
+func (t *T) Reserve(key string) (Reservation, bool) {
+	r := Reservation{key: key, expireAt: t.opts.clock.Now().Add(reservationTimeout)}
+
+	if _, ok := t.data[key]; ok || !t.slru.full() {
+		return r, true
+	}
+
+	t.vetoes = t.vetoes[:0]
+	victim := t.victim()
+	if victim == nil {
+		return Reservation{}, false
+	}
+
+	victimCount := t.countSketch.estimate(victim.Value.(*Item).keyh)
+	if t.countSketch.estimate(t.hash(key)) <= victimCount {
+		return Reservation{}, false
+	}
+	return r, true
+}
+
+// Commit stores value under the reserved key with the given TTL, where a ttl
+// <= 0 never expires. A new key goes straight to the probation segment,
+// evicting the current victim, instead of going through the window and
+// admission. It returns false if the reservation is older than 10 seconds or
+// value is rejected like Add would reject it.
+func (t *T) Commit(r Reservation, value interface{}, ttl time.Duration) bool {
+	now := t.opts.clock.Now()
+	if r.key == "" || now.After(r.expireAt) {
+		return false
+	}
+
+	item := &Item{Key: r.key, Value: value}
+	if ttl > 0 {
+		item.ExpireAt = now.Add(ttl)
+	}
+	if err := t.store(item, false, true); err != nil {
+		return false
+	}
+
+	// store keeps the expiry of a key that became resident meanwhile.
+	if e, ok := t.data[r.key]; ok {
+		e.Value.(*Item).ExpireAt = item.ExpireAt
+	}
+	return true
+}
+
+// place puts a new item in the probation segment, evicting the victim if the
+// segment is full, see Commit.
+func (t *T) place(item *Item) {
+	// Without a victim, because everything is pinned or protected, the
+	// segment grows past its capacity like insert does.
+	var victim *list.Element
+	if t.slru.full() {
+		victim = t.victim()
+	}
+	t.admit(item, victim)
+}
+
+// Reserve takes the write lock because choosing the victim may consult the
+// eviction veto, whose decisions are memoized.
+func (t *SyncT) Reserve(key string) (Reservation, bool) {
+	t.mu.Lock()
+	r, ok := t.t.Reserve(key)
+	t.mu.Unlock()
+
+	return r, ok
+}
+
+func (t *SyncT) Commit(r Reservation, value interface{}, ttl time.Duration) bool {
+	t.mu.Lock()
+	ok := t.t.Commit(r, value, ttl)
+	t.mu.Unlock()
+
+	return ok
+}
@@ -0,0 +1,123 @@
+package tinylfu
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vmihailenco/go-tinylfu/internal/list"
+)
+
+// Resize changes the capacity of the cache to newSize entries, keeping the
+// resident ones. The window and the segments get the sizes New would give
+// them. When shrinking, the entries with the lowest frequency estimates are
+// evicted until the cache fits, firing their callbacks with ReasonCapacity;
+// among entries of the same frequency probation goes first, then the window,
+// then protected, each from its least recently used end. Pinned or protected
+// entries are never evicted, so the cache may stay above newSize until they
+// are released.
+//
+// The frequency sketch is resized with the cache, unless WithSketchWidth fixed
+// its width. The estimates of the resident entries are carried over and those
+// of other keys are lost. The doorkeeper is sized by samples, not by the size,
+// and is kept.
+//
+// Like New, Resize clamps segments that would get no slot to one and reports
+// the error to the WithOnError callback. It returns an error wrapping
+// ErrInvalidSize, leaving the cache unchanged, only if newSize < 1.
+func (t *T) Resize(newSize int) error {
+	t.mustNotRange()
+	if newSize < 1 {
+		return fmt.Errorf("%w: size %d is below 1", ErrInvalidSize, newSize)
+	}
+	lruSize, slru20, slruSize, err := segments(newSize, &t.opts)
+	if err != nil && t.opts.onError != nil {
+		t.opts.onError(err)
+	}
+
+	t.vetoes = t.vetoes[:0]
+	if n := len(t.data) - newSize; n > 0 {
+		for _, item := range t.resizeVictims(n) {
+			// A callback fired by an earlier eviction may have changed the entry.
+			if e, ok := t.data[item.Key]; ok && e.Value.(*Item) == item {
+				t.evict(e)
+			}
+		}
+	}
+
+	t.lru.cap = lruSize
+	t.slru.onecap = slru20
+	t.slru.twocap = slruSize - slru20
+	t.rebalance()
+
+	if t.opts.sketchWidth == 0 && max(nextPowerOfTwo(uint32(newSize)), 2)-1 != t.countSketch.mask {
+		sketch := newSketch(newSize, &t.opts)
+		for _, e := range t.data {
+			keyh := e.Value.(*Item).keyh
+			for n := t.countSketch.estimate(keyh); n > 0; n-- {
+				sketch.add(keyh)
+			}
+		}
+		t.countSketch = sketch
+	}
+	if t.opts.scanGuard {
+		t.guard = newScanGuard(newSize)
+	}
+	t.bypass = newSize < t.opts.bypassBelow
+
+	t.checkFull()
+	return nil
+}
+
+// resizeVictims returns up to n evictable items, the least valuable first.
+func (t *T) resizeVictims(n int) []*Item {
+	var items []*Item
+	for _, l := range []*list.List{t.slru.one, t.lru.ll, t.slru.two} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			if item := e.Value.(*Item); t.canEvict(item) {
+				items = append(items, item)
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return t.countSketch.estimate(items[i].keyh) < t.countSketch.estimate(items[j].keyh)
+	})
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+// rebalance moves entries between the window and the segments until each fits
+// its capacity: protected entries are demoted to probation, and window
+// entries pass to probation as if they had been admitted. If the window is
+// below its capacity, probation hands its most recent entries to the window
+// so that the segments don't hold more than their share.
+func (t *T) rebalance() {
+	for t.slru.two.Len() > t.slru.twocap {
+		t.relink(t.slru.two.Back(), t.slru.one, 1)
+	}
+	for t.lru.ll.Len() > t.lru.cap {
+		t.relink(t.lru.ll.Back(), t.slru.one, 1)
+	}
+	for t.slru.Len() > t.slru.onecap+t.slru.twocap && t.lru.ll.Len() < t.lru.cap && t.slru.one.Len() > 0 {
+		t.relink(t.slru.one.Front(), t.lru.ll, 0)
+	}
+}
+
+// relink moves e to the front of the list to, which has the given listid.
+func (t *T) relink(e *list.Element, to *list.List, listid int) {
+	item := e.Value.(*Item)
+	t.listOf(e).Remove(e)
+
+	item.listid = listid
+	t.data[item.Key] = to.PushFront(item)
+}
+
+func (t *SyncT) Resize(newSize int) error {
+	t.mu.Lock()
+	err := t.t.Resize(newSize)
+	t.mu.Unlock()
+
+	return err
+}
@@ -0,0 +1,112 @@
+package tinylfu
+
+import "time"
+
+// Result is the outcome of a lookup together with the entry metadata.
+// The zero Result is a miss.
+type Result struct {
+	value     interface{}
+	found     bool
+	expired   bool
+	version   uint64
+	age       time.Duration
+	frequency uint8
+}
+
+// Value returns the cached value, or nil on a miss.
+func (r Result) Value() interface{} { return r.value }
+
+// Found reports whether the key was resident and not expired.
+func (r Result) Found() bool { return r.found }
+
+// Expired reports whether the key was resident but had expired.
+func (r Result) Expired() bool { return r.expired }
+
+// Version returns the entry version, or 0 on a miss.
+func (r Result) Version() uint64 { return r.version }
+
+// Age returns the time since the entry was inserted, or 0 on a miss.
+func (r Result) Age() time.Duration { return r.age }
+
+// Frequency returns the estimated access frequency of the key. It is
+// reported on misses too.
+func (r Result) Frequency() uint8 { return r.frequency }
+
+// String returns the value if it is a string.
+func (r Result) String() (string, bool) {
+	s, ok := r.value.(string)
+	return s, ok
+}
+
+// Bytes returns the value if it is a []byte.
+func (r Result) Bytes() ([]byte, bool) {
+	b, ok := r.value.([]byte)
+	return b, ok
+}
+
+// GetResult is like Get but returns the value together with its metadata.
+func (t *T) GetResult(key string) Result {
+	if t.coalesce != nil {
+		t.flushKey(key)
+		t.flushDue()
+	}
+
+	r := t.getResult(key)
+	t.observe(key, r.found)
+
+	return r
+}
+
+func (t *T) getResult(key string) Result {
+	keyh := t.access(key)
+	r := Result{frequency: t.countSketch.estimate(keyh)}
+
+	val, ok := t.data[key]
+	if !ok {
+		return r
+	}
+
+	item := val.Value.(*Item)
+	now := t.opts.clock.Now()
+	if item.expired(now) {
+		t.expire(val)
+		r.expired = true
+		return r
+	}
+
+	t.slide(item, now)
+	r.fill(item, now)
+	t.move(val)
+
+	return r
+}
+
+// PeekResult is like GetResult but does not count as an access: the frequency
+// sketch, the recency order and expired entries are left untouched.
+func (t *T) PeekResult(key string) Result {
+	t.flushPending(key)
+	r := Result{frequency: t.countSketch.estimate(t.hash(key))}
+
+	val, ok := t.data[key]
+	if !ok {
+		return r
+	}
+
+	item := val.Value.(*Item)
+	now := t.opts.clock.Now()
+	if item.expired(now) {
+		r.expired = true
+		return r
+	}
+
+	r.fill(item, now)
+
+	return r
+}
+
+func (r *Result) fill(item *Item, now time.Time) {
+	r.value = item.Value
+	r.found = true
+	r.version = item.Version
+	r.age = now.Sub(item.CreatedAt)
+}
@@ -1,23 +1,24 @@
 package tinylfu
 
-import (
-	"container/list"
-)
+import "github.com/vmihailenco/go-tinylfu/internal/list"
 
 // Cache is an LRU cache.  It is not safe for concurrent access.
 type slruCache struct {
 	data           map[string]*list.Element
 	onecap, twocap int
 	one, two       *list.List
+
+	// reuses and allocs count how often an element was reused or allocated
+	reuses, allocs uint64
 }
 
-func newSLRU(onecap, twocap int, data map[string]*list.Element) *slruCache {
+func newSLRU(onecap, twocap int, data map[string]*list.Element, one, two *list.List) *slruCache {
 	return &slruCache{
 		data:   data,
 		onecap: onecap,
-		one:    list.New(),
+		one:    one,
 		twocap: twocap,
-		two:    list.New(),
+		two:    two,
 	}
 }
 
@@ -38,6 +39,7 @@ func (slru *slruCache) get(v *list.Element) {
 		// just do the remove/add
 		slru.one.Remove(v)
 		item.listid = 2
+		slru.allocs++
 		slru.data[item.Key] = slru.two.PushFront(item)
 		return
 	}
@@ -60,35 +62,38 @@ func (slru *slruCache) get(v *list.Element) {
 	slru.two.MoveToFront(back)
 }
 
-// Set sets a value in the cache
-func (slru *slruCache) add(newItem *Item) {
+// Set sets a value in the cache, reusing the victim's slot if there is one
+func (slru *slruCache) add(newItem *Item, victim *list.Element) {
 	newItem.listid = 1
 
-	if slru.one.Len() < slru.onecap || (slru.Len() < slru.onecap+slru.twocap) {
+	if victim == nil {
+		slru.allocs++
 		slru.data[newItem.Key] = slru.one.PushFront(newItem)
 		return
 	}
 
-	// reuse the tail item
-	e := slru.one.Back()
-	item := e.Value.(*Item)
+	// reuse the victim item
+	item := victim.Value.(*Item)
 
 	delete(slru.data, item.Key)
 
+	if item.listid == 2 {
+		slru.two.Remove(victim)
+		slru.allocs++
+		slru.data[newItem.Key] = slru.one.PushFront(newItem)
+		return
+	}
+
+	slru.reuses++
 	*item = *newItem
 
-	slru.data[item.Key] = e
-	slru.one.MoveToFront(e)
+	slru.data[item.Key] = victim
+	slru.one.MoveToFront(victim)
 }
 
-func (slru *slruCache) victim() *Item {
-	if slru.Len() < slru.onecap+slru.twocap {
-		return nil
-	}
-
-	v := slru.one.Back()
-
-	return v.Value.(*Item)
+// full reports whether adding an item requires evicting another
+func (slru *slruCache) full() bool {
+	return slru.Len() >= slru.onecap+slru.twocap
 }
 
 // Len returns the total number of items in the cache
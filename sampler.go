@@ -0,0 +1,43 @@
+package tinylfu
+
+import (
+	"math"
+	"time"
+)
+
+// sampler makes cheap random sampling decisions with a xorshift64 generator.
+type sampler struct {
+	state     uint64
+	threshold uint64
+}
+
+func newSampler(rate float64) sampler {
+	s := sampler{
+		state: uint64(time.Now().UnixNano()) | 1,
+	}
+
+	switch {
+	case rate >= 1:
+		s.threshold = math.MaxUint64
+	case rate > 0:
+		s.threshold = uint64(rate * math.MaxUint64)
+	}
+
+	return s
+}
+
+// sample reports whether the current event is sampled.
+func (s *sampler) sample() bool {
+	x := s.next()
+	return x < s.threshold || s.threshold == math.MaxUint64
+}
+
+// next advances the generator and returns its new state.
+func (s *sampler) next() uint64 {
+	x := s.state
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	s.state = x
+	return x
+}
@@ -0,0 +1,55 @@
+package tinylfu
+
+const (
+	// scanGuardRatio is the share of new keys per interval above which the
+	// cache is considered to be scanned.
+	scanGuardRatio = 0.25
+	// scanGuardMargin is how much more frequent than the victim a candidate
+	// must be to be admitted during a scan.
+	scanGuardMargin = 4
+	// scanGuardMinInterval bounds how often the cardinality estimate is
+	// computed.
+	scanGuardMinInterval = 1024
+)
+
+// scanGuard detects scans from the growth of the distinct key estimate.
+//
+// Every interval accesses, where interval is the cache size but at least
+// 1024, it compares the number of new distinct keys with the number of
+// accesses. If more than a quarter of the accesses were to keys not seen
+// before in the current epoch the cache is considered to be scanned, and
+// candidates need a frequency estimate scanGuardMargin above their victim to
+// be admitted. The guard relaxes after the first interval with fewer new keys.
+type scanGuard struct {
+	interval int
+	accesses int
+	distinct uint64
+	scanning bool
+}
+
+func newScanGuard(size int) *scanGuard {
+	interval := size
+	if interval < scanGuardMinInterval {
+		interval = scanGuardMinInterval
+	}
+	return &scanGuard{interval: interval}
+}
+
+func (g *scanGuard) access(h *hll) {
+	g.accesses++
+	if g.accesses < g.interval {
+		return
+	}
+
+	distinct := h.estimate()
+	fresh := float64(distinct) - float64(g.distinct)
+	g.scanning = fresh > scanGuardRatio*float64(g.accesses)
+	g.distinct = distinct
+	g.accesses = 0
+}
+
+// reset restarts counting after the cardinality estimator was reset.
+func (g *scanGuard) reset() {
+	g.accesses = 0
+	g.distinct = 0
+}
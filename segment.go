@@ -0,0 +1,51 @@
+package tinylfu
+
+// Segment identifies the part of the cache holding an entry.
+type Segment int
+
+const (
+	// SegmentWindow is the admission window new entries go to.
+	SegmentWindow Segment = iota
+	// SegmentProbation holds entries admitted from the window.
+	SegmentProbation
+	// SegmentProtected holds entries hit while on probation.
+	SegmentProtected
+)
+
+func (s Segment) String() string {
+	switch s {
+	case SegmentWindow:
+		return "window"
+	case SegmentProbation:
+		return "probation"
+	case SegmentProtected:
+		return "protected"
+	}
+	return "unknown"
+}
+
+// SegmentOf returns the segment holding key. Like Peek it has no side effects.
+// It returns false if the key is missing or expired.
+func (t *T) SegmentOf(key string) (Segment, bool) {
+	t.flushPending(key)
+
+	val, ok := t.data[key]
+	if !ok {
+		return 0, false
+	}
+
+	item := val.Value.(*Item)
+	if item.expired(t.opts.clock.Now()) {
+		return 0, false
+	}
+
+	return Segment(item.listid), true
+}
+
+func (t *SyncT) SegmentOf(key string) (Segment, bool) {
+	t.rlock()
+	s, ok := t.t.SegmentOf(key)
+	t.runlock()
+
+	return s, ok
+}
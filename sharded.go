@@ -0,0 +1,98 @@
+package tinylfu
+
+import "github.com/cespare/xxhash/v2"
+
+var _ LFU = (*ShardedCache)(nil)
+
+// ShardedCache spreads keys over independent SyncT shards, each with its own
+// lock, so that concurrent operations on keys of different shards don't
+// contend. Every shard runs its own admission with its own frequency sketch,
+// so a key only competes with the keys of its shard.
+type ShardedCache struct {
+	shards []*SyncT
+	hash   func(key string) uint64
+}
+
+// NewSharded creates a cache of size entries split evenly over shards SyncT
+// shards; shards < 1 is treated as 1. samples is split the same way, and opts
+// apply to every shard. Each shard must get at least the minimum size of New,
+// so size should be at least 100 times shards. Keys are assigned to shards by
+// the hash of WithHasher.
+func NewSharded(size, samples, shards int, opts ...Option) *ShardedCache {
+	if shards < 1 {
+		shards = 1
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &ShardedCache{
+		shards: make([]*SyncT, shards),
+		hash:   o.hasher,
+	}
+	if c.hash == nil {
+		c.hash = xxhash.Sum64String
+	}
+	for i := range c.shards {
+		c.shards[i] = NewSync(split(size, shards, i), split(samples, shards, i), opts...)
+	}
+	return c
+}
+
+// split returns the part i of n split into shards parts, giving the
+// remainder to the first parts.
+func split(n, shards, i int) int {
+	part := n / shards
+	if i < n%shards {
+		part++
+	}
+	return part
+}
+
+// shard returns the shard of key. It uses the high half of the hash: the
+// sketch and the doorkeeper of a shard index by the low bits, which must stay
+// evenly distributed across the keys of a shard.
+func (c *ShardedCache) shard(key string) *SyncT {
+	h := c.hash(key) >> 32
+	return c.shards[h%uint64(len(c.shards))]
+}
+
+func (c *ShardedCache) Get(key string) (interface{}, bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *ShardedCache) Add(newItem *Item) error {
+	return c.shard(newItem.Key).Add(newItem)
+}
+
+func (c *ShardedCache) Set(newItem *Item) {
+	c.shard(newItem.Key).Set(newItem)
+}
+
+func (c *ShardedCache) Del(key string) {
+	c.shard(key).Del(key)
+}
+
+// Len returns the number of resident entries of all shards. The shards are
+// locked one at a time, so the result isn't a consistent snapshot under
+// concurrent writes.
+func (c *ShardedCache) Len() int {
+	var n int
+	for _, s := range c.shards {
+		n += s.Len()
+	}
+	return n
+}
+
+// Close closes every shard and returns the first error.
+func (c *ShardedCache) Close() error {
+	var err error
+	for _, s := range c.shards {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package tinylfu
+
+import "time"
+
+// Config describes how to build a cache with New.
+type Config struct {
+	Size    int
+	Samples int
+	Options []Option
+}
+
+// Simulate replays trace against a new cache built from cfg and returns its
+// stats. Each key is read with Get and stored with Set on a miss, as a
+// cache-aside client would do. Unless cfg sets a clock, the cache runs on a
+// simulated one that starts at the Unix epoch and advances by a millisecond
+// per key, so replaying the same trace with the same config always gives the
+// same result.
+func Simulate(trace []string, cfg Config) Stats {
+	clock := &stepClock{now: time.Unix(0, 0)}
+	opts := append([]Option{WithClock(clock)}, cfg.Options...)
+
+	t := New(cfg.Size, cfg.Samples, opts...)
+	for _, key := range trace {
+		clock.now = clock.now.Add(time.Millisecond)
+		if _, ok := t.Get(key); !ok {
+			t.Set(&Item{Key: key, Value: struct{}{}})
+		}
+	}
+	return t.Stats()
+}
+
+// stepClock is the simulated clock of Simulate.
+type stepClock struct {
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time { return c.now }
+
+// CompareConfigs replays the same trace against caches built from a and b,
+// see Simulate.
+func CompareConfigs(trace []string, a, b Config) (statsA, statsB Stats) {
+	return Simulate(trace, a), Simulate(trace, b)
+}
+
+// SizeForHitRatio returns the smallest cache size whose Simulate hit ratio on
+// trace reaches target, together with the hit ratio it achieved. Sizes are
+// binary searched between 1 and the number of distinct keys in trace, which
+// assumes the hit ratio grows with the size; that holds closely but not
+// exactly for TinyLFU. If even a cache holding every key misses the target,
+// that size and its hit ratio are returned.
+//
+// The result is only as good as the trace: it is the size for that traffic,
+// and a trace much shorter than samples never exercises the sketch aging.
+func SizeForHitRatio(trace []string, target float64, samples int) (size int, achieved float64) {
+	distinct := make(map[string]struct{})
+	for _, key := range trace {
+		distinct[key] = struct{}{}
+	}
+
+	hitRatio := func(size int) float64 {
+		return Simulate(trace, Config{Size: size, Samples: samples}).HitRatio()
+	}
+
+	hi := len(distinct)
+	if hi == 0 {
+		return 0, 0
+	}
+	achieved = hitRatio(hi)
+	if achieved < target {
+		return hi, achieved
+	}
+
+	lo := 1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if r := hitRatio(mid); r >= target {
+			hi, achieved = mid, r
+		} else {
+			lo = mid + 1
+		}
+	}
+	return hi, achieved
+}
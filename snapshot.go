@@ -0,0 +1,61 @@
+package tinylfu
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Snapshot writes the live entries of the cache to w as a stream of gob
+// encoded entries, for Restore to warm up a cache after a restart. Values are
+// gob encoded as interface values, so types other than the basic ones must be
+// registered with gob.Register; the error for a value gob can't encode names
+// its key. OnEvict callbacks can't be encoded and are dropped, so they must be
+// attached again after Restore, e.g. with SetOnEvict. Unlike Save it always
+// uses gob, whatever WithCodec is set to.
+func (t *T) Snapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	for _, e := range t.savedEntries() {
+		if err := enc.Encode(&e); err != nil {
+			return fmt.Errorf("snapshot of key %q: %w", e.Key, err)
+		}
+	}
+	return nil
+}
+
+// Restore reads the entries written by Snapshot and stores them with Set, so
+// they go through the normal admission and capacity rules. Entries that have
+// expired since the snapshot are skipped. On a decoding error the entries
+// read so far are kept.
+func (t *T) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	for {
+		var e SavedEntry
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := t.restore([]SavedEntry{e}); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *SyncT) Snapshot(w io.Writer) error {
+	t.rlock()
+	err := t.t.Snapshot(w)
+	t.runlock()
+
+	return err
+}
+
+func (t *SyncT) Restore(r io.Reader) error {
+	t.mu.Lock()
+	err := t.t.Restore(r)
+	t.mu.Unlock()
+
+	return err
+}
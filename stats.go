@@ -0,0 +1,173 @@
+package tinylfu
+
+import "time"
+
+// Stats describes the state of a cache.
+type Stats struct {
+	// Hits and Misses count the outcomes of Gets.
+	Hits   uint64
+	Misses uint64
+
+	// TotalEvictions counts the entries removed to make room over the
+	// lifetime of the cache: admission victims, candidates rejected by
+	// admission and entries evicted for the byte budget. Del and expiry
+	// don't count. EvictionsSinceReset counts the same since the last
+	// ResetStats.
+	TotalEvictions      uint64
+	EvictionsSinceReset uint64
+	// Expirations counts the entries removed because they expired.
+	Expirations uint64
+	// WindowEvictions and ProbationEvictions split TotalEvictions by where
+	// the entries were evicted from. WindowEvictions counts the candidates
+	// leaving the window that lost admission along with window entries
+	// evicted for the byte budget; many of them mean the window churns
+	// through keys seen once. ProbationEvictions counts the entries evicted
+	// from the main segments, which mean the main cache is under pressure.
+	// It is named after probation since protected entries are demoted
+	// there before they can be evicted, unless every probation entry is
+	// pinned or protected.
+	WindowEvictions    uint64
+	ProbationEvictions uint64
+	// Rejections counts the items rejected by admission or because they
+	// didn't fit the byte budget. Rejected candidates leaving the window
+	// are counted in TotalEvictions too.
+	Rejections uint64
+	// LastAdmit is when an item leaving the window last won the frequency
+	// comparison against an eviction victim, or the zero time if none has.
+	// Items admitted while the cache fills up don't count. A LastAdmit far
+	// in the past under a steady stream of new keys means the resident set
+	// never changes, e.g. because it is all pinned, protected or just hot.
+	LastAdmit time.Time
+
+	// Size is the number of resident entries.
+	Size int
+	// Capacity is the maximum number of resident entries.
+	Capacity int
+	// Pinned is the number of pinned entries.
+	Pinned int
+	// Bytes is the total size of the values, see Item.Size.
+	Bytes int64
+	// SlotReuses counts evictions that reused the list element of the
+	// evicted entry and SlotAllocs counts list elements allocated. Once the
+	// cache is warm reuses keep climbing while allocations level off.
+	SlotReuses uint64
+	SlotAllocs uint64
+	// EvictCallbacks and EvictCallbackDuration count the OnEvict and
+	// expiry callbacks and the time spent in them when WithCallbackTiming
+	// is used.
+	EvictCallbacks        uint64
+	EvictCallbackDuration time.Duration
+	// CallbackErrors counts the callback panics recovered when
+	// WithCallbackRecovery is used and LastCallbackError is the last one.
+	CallbackErrors    uint64
+	LastCallbackError error
+}
+
+// Stats returns the current cache statistics.
+func (t *T) Stats() Stats {
+	return Stats{
+		Hits:   t.hits,
+		Misses: t.misses,
+
+		TotalEvictions:      t.evictions,
+		EvictionsSinceReset: t.evictionsSinceReset,
+		Expirations:         t.expirations,
+		WindowEvictions:     t.windowEvictions,
+		ProbationEvictions:  t.mainEvictions,
+		Rejections:          t.rejections,
+		LastAdmit:           t.lastAdmit,
+
+		Size:     len(t.data),
+		Capacity: t.capacity(),
+		Pinned:   t.pinned,
+		Bytes:    t.bytes,
+
+		SlotReuses: t.lru.reuses + t.slru.reuses,
+		SlotAllocs: t.lru.allocs + t.slru.allocs,
+
+		EvictCallbacks:        t.callbacks,
+		EvictCallbackDuration: t.callbackTime,
+		CallbackErrors:        t.callbackErrors,
+		LastCallbackError:     t.lastCallbackError,
+	}
+}
+
+// ResetStats zeroes the counters reported as since the last reset, such as
+// Stats.EvictionsSinceReset. Lifetime counters are kept.
+func (t *T) ResetStats() {
+	t.evictionsSinceReset = 0
+}
+
+// HitRatio returns the fraction of Gets that were hits.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// OldestEntryAge returns the age of the live entry inserted first, or 0 if
+// the cache is empty. Ages are measured from CreatedAt with the cache clock.
+// It walks the whole cache.
+func (t *T) OldestEntryAge() time.Duration {
+	oldest, _ := t.entryAges()
+	return oldest
+}
+
+// NewestEntryAge returns the age of the most recently inserted live entry, or
+// 0 if the cache is empty. It walks the whole cache.
+func (t *T) NewestEntryAge() time.Duration {
+	_, newest := t.entryAges()
+	return newest
+}
+
+func (t *T) entryAges() (oldest, newest time.Duration) {
+	now := t.opts.clock.Now()
+	first := true
+	for _, e := range t.data {
+		item := e.Value.(*Item)
+		if item.expired(now) {
+			continue
+		}
+
+		age := now.Sub(item.CreatedAt)
+		if first || age > oldest {
+			oldest = age
+		}
+		if first || age < newest {
+			newest = age
+		}
+		first = false
+	}
+	return oldest, newest
+}
+
+func (t *SyncT) Stats() Stats {
+	t.mu.RLock()
+	stats := t.t.Stats()
+	t.mu.RUnlock()
+
+	return stats
+}
+
+func (t *SyncT) OldestEntryAge() time.Duration {
+	t.mu.RLock()
+	age := t.t.OldestEntryAge()
+	t.mu.RUnlock()
+
+	return age
+}
+
+func (t *SyncT) NewestEntryAge() time.Duration {
+	t.mu.RLock()
+	age := t.t.NewestEntryAge()
+	t.mu.RUnlock()
+
+	return age
+}
+
+func (t *SyncT) ResetStats() {
+	t.mu.Lock()
+	t.t.ResetStats()
+	t.mu.Unlock()
+}
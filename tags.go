@@ -0,0 +1,73 @@
+package tinylfu
+
+// tag adds a newly stored item to the tag index.
+func (t *T) tag(item *Item) {
+	if len(item.Tags) == 0 {
+		return
+	}
+	if t.tags == nil {
+		t.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range item.Tags {
+		keys, ok := t.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			t.tags[tag] = keys
+		}
+		keys[item.Key] = struct{}{}
+	}
+}
+
+// untag removes an item leaving the cache from the tag index.
+func (t *T) untag(item *Item) {
+	for _, tag := range item.Tags {
+		keys := t.tags[tag]
+		delete(keys, item.Key)
+		if len(keys) == 0 {
+			delete(t.tags, tag)
+		}
+	}
+}
+
+// InvalidateTag removes all resident entries carrying tag, firing their
+// OnEvict callbacks like Del, and returns how many were removed.
+//
+// Tags are looked up in an index from each tag to the keys carrying it, so
+// the cost is proportional to the number of tagged entries rather than to the
+// size of the cache. The index costs one map entry per tag of every resident
+// entry plus one map per distinct tag.
+func (t *T) InvalidateTag(tag string) int {
+	keys := make([]string, 0, len(t.tags[tag]))
+	for key := range t.tags[tag] {
+		keys = append(keys, key)
+	}
+
+	var n int
+	for _, key := range keys {
+		// A callback fired by an earlier removal may have changed the entry.
+		val, ok := t.data[key]
+		if !ok || !hasTag(val.Value.(*Item), tag) {
+			continue
+		}
+		t.del(val)
+		n++
+	}
+	return n
+}
+
+func hasTag(item *Item, tag string) bool {
+	for _, s := range item.Tags {
+		if s == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SyncT) InvalidateTag(tag string) int {
+	t.mu.Lock()
+	n := t.t.InvalidateTag(tag)
+	t.mu.Unlock()
+
+	return n
+}
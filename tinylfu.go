@@ -5,12 +5,14 @@
 package tinylfu
 
 import (
-	"container/list"
 	"errors"
+	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
+	"github.com/vmihailenco/go-tinylfu/internal/list"
 )
 
 // LFU interface
@@ -27,13 +29,46 @@ type Item struct {
 	Value    interface{}
 	ExpireAt time.Time
 	OnEvict  func()
+	// OnEvictReason is like OnEvict but tells why the entry left. It is
+	// fired after OnEvict when both are set, and also when Set replaces
+	// the value, with the old value and ReasonReplaced.
+	OnEvictReason func(key string, value interface{}, reason EvictionReason)
+	// Cost is the weight of the entry against WithMaxCost. When it is 0
+	// the size of the value is used instead, see Item.Size.
+	Cost int64
+
+	// Version is maintained by the cache: it is 1 when the key is inserted
+	// and incremented each time Set replaces the value.
+	Version uint64
+	// CreatedAt is set by the cache when the key is inserted.
+	CreatedAt time.Time
+	// SourceTime is when the value was produced at its source. It is
+	// optional and only used to resolve conflicts in Merge.
+	SourceTime time.Time
+	// ProtectUntil protects the item from capacity eviction until the given
+	// time. Victim selection skips protected items, so protecting many
+	// items starves admission just like pinning them does.
+	ProtectUntil time.Time
+	// Tags are labels for InvalidateTag. Setting a resident key replaces
+	// its tags.
+	Tags []string
+	// SlidingTTL makes every Get hit set ExpireAt to SlidingTTL from the
+	// time of the hit, so the entry expires once it hasn't been read for
+	// that long. An item stored without ExpireAt gets one. It overrides
+	// WithSlidingTTL.
+	SlidingTTL time.Duration
 
 	listid int
 	keyh   uint64
+	pinned bool
+	size   int64
+	// staleAt is when the entry exceeds WithMaxAge.
+	staleAt time.Time
 }
 
-func (item Item) expired() bool {
-	return !item.ExpireAt.IsZero() && time.Now().After(item.ExpireAt)
+func (item *Item) expired(now time.Time) bool {
+	return !item.ExpireAt.IsZero() && now.After(item.ExpireAt) ||
+		!item.staleAt.IsZero() && now.After(item.staleAt)
 }
 
 var _ LFU = (*T)(nil)
@@ -42,66 +77,311 @@ var _ LFU = (*T)(nil)
 type T struct {
 	w       int
 	samples int
+	epoch   uint64
+	// bypass turns off admission, see WithAdmissionBypass.
+	bypass bool
+	// grace is the number of Gets after a reset during which admission is
+	// eased, see WithResetGrace.
+	grace int
 
 	countSketch *cm4
-	bouncer     *doorkeeper
+	bouncer     filter
+	// distinct and repeated are the key estimates of WithKeyEstimates.
+	// WithScanGuard keeps distinct as well. They are nil otherwise.
+	distinct *hll
+	repeated *hll
 
 	data map[string]*list.Element
+	// tags indexes the keys of the resident entries by tag.
+	tags map[string]map[string]struct{}
 
 	lru  *lruCache
 	slru *slruCache
+
+	opts options
+
+	sampler  sampler
+	jitter   sampler
+	coalesce *coalescer
+	guard    *scanGuard
+
+	hits   uint64
+	misses uint64
+
+	evictions           uint64
+	evictionsSinceReset uint64
+	expirations         uint64
+	rejections          uint64
+	windowEvictions     uint64
+	mainEvictions       uint64
+	// full is whether the cache was at capacity when last checked, see
+	// WithOnFull.
+	full bool
+	// lastAdmit is when an item last won admission over a victim.
+	lastAdmit time.Time
+	// evicted is the first entry removed to make room since didEvict was
+	// cleared, see SetWithEvicted.
+	evicted  Item
+	didEvict bool
+
+	// pinned is the number of pinned entries.
+	pinned int
+	// callbacks and callbackTime track callbacks when WithCallbackTiming
+	// is used.
+	callbacks    uint64
+	callbackTime time.Duration
+	// callbackErrors and lastCallbackError track the callback panics
+	// recovered when WithCallbackRecovery is used.
+	callbackErrors    uint64
+	lastCallbackError error
+
+	// vetoes holds the keys vetoed during the current insert.
+	vetoes []string
+	// bytes is the total size of the values, see Item.Size.
+	bytes int64
+
+	// valueType is the type of the first value stored when
+	// WithTypeEnforcement is used.
+	valueType reflect.Type
+
+	// resetting is set while an incremental reset is in progress and
+	// resetPos is how far it got.
+	resetting bool
+	resetPos  int
+
+	// ranging is the number of Range, RangeProtected and All walks in
+	// progress, see mustNotRange. SyncT runs walks under the read lock, so
+	// it is updated atomically.
+	ranging int32
 }
 
 // New constructor.
-func New(size int, samples int) *T {
-	const lruPct = 1
+//
+// Caches too small to give every segment at least one slot are still created,
+// with the empty segments clamped to one slot; the error is reported to the
+// WithOnError callback. Option values out of range are reported the same way
+// and replaced by the defaults. Use NewChecked to fail instead. New panics if
+// size < 1.
+func New(size int, samples int, opts ...Option) *T {
+	t, err := newT(size, samples, opts)
+	if t == nil {
+		panic(err)
+	}
+	if err != nil && t.opts.onError != nil {
+		t.opts.onError(err)
+	}
+	return t
+}
 
-	lruSize := (lruPct * size) / 100
-	if lruSize < 1 {
-		lruSize = 1
+// NewChecked is like New but returns an error wrapping ErrInvalidSize if size
+// can't be split into the window, probation and protected segments without
+// clamping one of them, or wrapping ErrInvalidOption if an option value is
+// out of range. It is meant for libraries embedding the cache that want to
+// fail on misconfiguration rather than run a degenerate cache. The error names
+// the empty segment and the minimum viable size, which depends on the
+// segment options: with the default ratios it is 100.
+func NewChecked(size int, samples int, opts ...Option) (*T, error) {
+	t, err := newT(size, samples, opts)
+	if err != nil {
+		return nil, err
 	}
-	slruSize := int(float64(size) * ((100.0 - lruPct) / 100.0))
-	if slruSize < 1 {
-		slruSize = 1
+	return t, nil
+}
+
+// NewWithOptions is like New but returns an error wrapping ErrInvalidOption if
+// an option value is out of range, such as WithWindowPercent(0). New ignores
+// such values and keeps the defaults. Without options the cache is the same as
+// one created by New.
+func NewWithOptions(size int, samples int, opts ...Option) (*T, error) {
+	t, err := newT(size, samples, opts)
+	if t == nil || errors.Is(err, ErrInvalidOption) {
+		return nil, err
 	}
-	slru20 := int(0.2 * float64(slruSize))
-	if slru20 < 1 {
-		slru20 = 1
+	if err != nil && t.opts.onError != nil {
+		t.opts.onError(err)
+	}
+	return t, nil
+}
+
+func newT(size int, samples int, opts []Option) (*T, error) {
+	o := options{
+		clock: realClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	lruSize, slru20, slruSize, err := segments(size, &o)
+	if size < 1 {
+		// There is nothing to clamp to.
+		return nil, err
+	}
+	if o.err != nil {
+		err = o.err
 	}
 
 	data := make(map[string]*list.Element, size)
 
-	return &T{
+	t := &T{
 		w:       0,
 		samples: samples,
 
-		countSketch: newCM4(size),
-		bouncer:     newDoorkeeper(samples, 0.01),
+		countSketch: newSketch(size, &o),
 
 		data: data,
 
-		lru:  newLRU(lruSize, data),
-		slru: newSLRU(slru20, slruSize-slru20, data),
+		lru:  newLRU(lruSize, data, o.newList()),
+		slru: newSLRU(slru20, slruSize-slru20, data, o.newList(), o.newList()),
+
+		opts: o,
+
+		sampler: newSampler(o.sampleRate),
+		jitter:  newSampler(0),
+	}
+
+	if o.doorkeeper != nil {
+		t.bouncer = customDoorkeeper{o.doorkeeper}
+	} else {
+		fp := 0.01
+		if o.doorkeeperFP > 0 {
+			fp = o.doorkeeperFP
+		}
+		t.bouncer = newDoorkeeper(samples, fp)
+	}
+	if o.keyEstimates || o.scanGuard {
+		t.distinct = new(hll)
+	}
+	if o.keyEstimates {
+		t.repeated = new(hll)
+	}
+	if o.scanGuard {
+		t.guard = newScanGuard(size)
+	}
+	t.bypass = size < o.bypassBelow
+	if o.resetGrace > 0 {
+		t.grace = int(o.resetGrace * float64(samples))
+	}
+
+	if o.coalesceWindow > 0 {
+		t.coalesce = newCoalescer(o.coalesceWindow, o.coalesceSink)
+	}
+
+	return t, err
+}
+
+// ErrInvalidSize is returned by NewChecked if the cache size can't be split
+// into segments, and by Resize if the size is below 1.
+var ErrInvalidSize = errors.New("invalid size")
+
+// segments returns the capacities of the window, the probation segment and the
+// whole slru for a cache of the given size. Segments that would get no slot
+// are clamped to one and reported in the error.
+func segments(size int, o *options) (lruSize, slru20, slruSize int, err error) {
+	lruSize, slru20, slruSize, clamped := segmentSizes(size, o)
+	if clamped == "" {
+		return lruSize, slru20, slruSize, nil
+	}
+
+	min := size + 1
+	for _, _, _, c := segmentSizes(min, o); c != ""; _, _, _, c = segmentSizes(min, o) {
+		min++
+	}
+
+	return lruSize, slru20, slruSize, fmt.Errorf(
+		"%w: size %d leaves the %s segment empty, the minimum size is %d",
+		ErrInvalidSize, size, clamped, min)
+}
+
+// segmentSizes splits size entries into the window (1% by default, see
+// WithWindowPercent), probation (20% of the rest by default, see
+// WithSLRUProtectedRatio) and protected segments. The window and probation
+// sizes are rounded down and the protected segment takes the remainder, so the
+// three add up to exactly size unless a segment had to be clamped to one
+// entry. With the defaults:
+//
+//	size   window  probation  protected
+//	100    1       19         80
+//	150    1       29         120
+//	999    9       198        792
+//	1000   10      198        792
+//	1234   12      244        978
+func segmentSizes(size int, o *options) (lruSize, slru20, slruSize int, clamped string) {
+	// The defaults use integer arithmetic so that the sizes don't depend on
+	// floating point rounding.
+	lruSize = size / 100
+	if o.windowPct > 0 {
+		lruSize = int(o.windowPct * float64(size) / 100)
+	}
+	if lruSize < 1 {
+		lruSize = 1
+		clamped = "window"
+	}
+	slruSize = size - lruSize
+	if slruSize < 1 {
+		slruSize = 1
+		if clamped == "" {
+			clamped = "main"
+		}
+	}
+	slru20 = slruSize / 5
+	if o.protectedRatio > 0 {
+		slru20 = slruSize - int(o.protectedRatio*float64(slruSize))
+	}
+	if slru20 < 1 {
+		slru20 = 1
+		if clamped == "" {
+			clamped = "probation"
+		}
 	}
+	if slruSize-slru20 < 1 && clamped == "" {
+		clamped = "protected"
+	}
+
+	return lruSize, slru20, slruSize, clamped
+}
+
+func (t *T) onEvict(item *Item, reason EvictionReason) {
+	t.notify(item, reason, t.opts.onEvict)
 }
 
-func (t *T) onEvict(item *Item) {
-	if item.OnEvict != nil {
-		item.OnEvict()
+// callback runs an eviction or expiry callback, timing it when
+// WithCallbackTiming is used and recovering its panic when
+// WithCallbackRecovery is used.
+func (t *T) callback(fn func()) {
+	if t.opts.recoverCallbacks {
+		defer t.recoverCallback()
+	}
+
+	if !t.opts.timeCallbacks {
+		fn()
+		return
 	}
+
+	start := t.opts.clock.Now()
+	fn()
+	t.callbackTime += t.opts.clock.Now().Sub(start)
+	t.callbacks++
 }
 
 // Get return an item from cache based on key.
 func (t *T) Get(key string) (interface{}, bool) {
-	t.w++
-	if t.w == t.samples {
-		t.countSketch.reset()
-		t.bouncer.reset()
-		t.w = 0
+	if t.coalesce != nil {
+		t.flushKey(key)
+		t.flushDue()
 	}
 
-	keyh := xxhash.Sum64String(key)
-	t.countSketch.add(keyh)
+	value, ok := t.get(key)
+	t.observe(key, ok)
+
+	return value, ok
+}
+
+// Peek returns the value of key without counting as an access: the frequency
+// sketch, the recency order, the sample counter and the hit and miss counters
+// are left untouched, and an expired entry is reported as a miss but not
+// removed.
+func (t *T) Peek(key string) (interface{}, bool) {
+	t.flushPending(key)
 
 	val, ok := t.data[key]
 	if !ok {
@@ -109,37 +389,274 @@ func (t *T) Get(key string) (interface{}, bool) {
 	}
 
 	item := val.Value.(*Item)
-	if item.expired() {
-		t.del(val)
+	if item.expired(t.opts.clock.Now()) {
+		return nil, false
+	}
+
+	return item.Value, true
+}
+
+func (t *T) get(key string) (interface{}, bool) {
+	t.access(key)
+
+	val, ok := t.data[key]
+	if !ok {
+		return nil, false
+	}
+
+	item := val.Value.(*Item)
+	now := t.opts.clock.Now()
+	if item.expired(now) {
+		t.expire(val)
 		return nil, false
 	}
 
 	// Save the value since it is overwritten below.
 	value := item.Value
 
-	if item.listid == 0 {
-		t.lru.get(val)
+	t.slide(item, now)
+	t.move(val)
+
+	return value, true
+}
+
+// hash returns the hash of key, see WithHasher.
+func (t *T) hash(key string) uint64 {
+	if t.opts.hasher != nil {
+		return t.opts.hasher(key)
+	}
+	return xxhash.Sum64String(key)
+}
+
+// access records an access to key in the frequency sketch and returns the key
+// hash.
+func (t *T) access(key string) uint64 {
+	t.mustNotRange()
+
+	t.w++
+	if t.w == t.samples {
+		t.reset()
+		t.w = 0
+	} else if t.resetting {
+		t.resetChunk()
+	}
+
+	keyh := t.hash(key)
+	t.countSketch.add(keyh)
+	if t.distinct != nil {
+		t.distinct.add(keyh)
+	}
+	if t.repeated != nil && t.countSketch.estimate(keyh) > 1 {
+		t.repeated.add(keyh)
+	}
+
+	if t.guard != nil {
+		t.guard.access(t.distinct)
+	}
+
+	return keyh
+}
+
+// observe reports the outcome of a Get.
+func (t *T) observe(key string, hit bool) {
+	if hit {
+		t.hits++
+		if t.opts.onHit != nil {
+			t.opts.onHit(key)
+		}
+	} else {
+		t.misses++
+		if t.opts.onMiss != nil {
+			t.opts.onMiss(key)
+		}
+	}
+
+	if t.opts.sampler != nil && t.sampler.sample() {
+		t.opts.sampler(key, hit)
+	}
+}
+
+// move updates the recency of a resident element.
+func (t *T) move(val *list.Element) {
+	if val.Value.(*Item).listid == 0 {
+		if t.opts.policy != PolicyFIFO {
+			t.lru.get(val)
+		}
 	} else {
 		t.slru.get(val)
 	}
+}
 
-	return value, true
+// reset ages the frequency sketch and clears the doorkeeper, either at once or
+// incrementally when WithIncrementalReset is used.
+func (t *T) reset() {
+	t.epoch++
+	t.resetEstimates()
+
+	if t.opts.resetStep <= 0 {
+		t.countSketch.reset()
+		t.bouncer.reset()
+		return
+	}
+
+	if t.resetting {
+		// Finish the previous reset before starting over.
+		t.countSketch.resetRange(t.resetPos, t.countSketch.len())
+		t.bouncer.resetRange(t.resetPos, t.bouncer.len())
+	}
+
+	t.resetting = true
+	t.resetPos = 0
+	t.resetChunk()
+}
+
+// resetChunk advances an incremental reset by one step.
+func (t *T) resetChunk() {
+	start := t.resetPos
+	end := start + t.opts.resetStep
+	t.resetPos = end
+
+	done := true
+	if n := t.countSketch.len(); start < n {
+		if end < n {
+			n, done = end, false
+		}
+		t.countSketch.resetRange(start, n)
+	}
+	if n := t.bouncer.len(); start < n {
+		if end < n {
+			n, done = end, false
+		}
+		t.bouncer.resetRange(start, n)
+	}
+
+	if done {
+		t.resetting = false
+	}
+}
+
+// Epoch returns the number of times the frequency sketch has been aged, which
+// happens every samples Gets. Logging it with hit rates helps attribute changes
+// to epoch boundaries.
+func (t *T) Epoch() uint64 {
+	return t.epoch
+}
+
+// Frequency returns the estimated access frequency of key from the frequency
+// sketch, whether or not the key is resident. It is the count admission
+// compares, between 0 and 15 and halved every samples Gets. Hash collisions
+// can only make it higher than the true count. It does not count as an access.
+func (t *T) Frequency(key string) uint8 {
+	return t.countSketch.estimate(t.hash(key))
+}
+
+// DistinctKeysSeen returns an estimate of the number of distinct keys read or
+// written since the last sketch reset. The estimate has a standard error of
+// about 1.6%. A count far above the cache size hints at a scan or churn. It is
+// 0 unless WithKeyEstimates or WithScanGuard is used.
+func (t *T) DistinctKeysSeen() uint64 {
+	if t.distinct == nil {
+		return 0
+	}
+	return t.distinct.estimate()
+}
+
+// WorkingSetEstimate returns an estimate of the number of distinct keys read
+// more than once since the last sketch reset, i.e. the keys worth caching. A
+// key counts once the frequency sketch estimates it was read at least twice.
+// Hash collisions in the sketch make the count an overestimate, the more so
+// the more distinct keys are seen relative to the cache size. An estimate far
+// above the cache size means the cache is too small for the working set. It
+// is 0 unless WithKeyEstimates is used.
+func (t *T) WorkingSetEstimate() int {
+	if t.repeated == nil {
+		return 0
+	}
+	return int(t.repeated.estimate())
+}
+
+// resetEstimates starts the key estimates and the scan guard over.
+func (t *T) resetEstimates() {
+	if t.distinct != nil {
+		t.distinct.reset()
+	}
+	if t.repeated != nil {
+		t.repeated.reset()
+	}
+	if t.guard != nil {
+		t.guard.reset()
+	}
+}
+
+// DoorkeeperDensity returns the fraction of bits set in the doorkeeper bloom
+// filter. As it approaches 1 the doorkeeper admits nearly everything, which
+// means samples is too large for the rate of distinct keys.
+func (t *T) DoorkeeperDensity() float64 {
+	return t.bouncer.density()
 }
 
 // ErrorKeyAlreadyExists will be returned by Add operations if the key already exists.
 var ErrKeyAlreadyExists = errors.New("key already exists")
 
+// ErrInvalidItem will be returned by Add operations if the item is rejected by
+// the cache configuration.
+var ErrInvalidItem = errors.New("invalid item")
+
 // Add will set an item on cache. If the key already exists the action fails.
 func (t *T) Add(newItem *Item) error {
+	if t.coalesce != nil {
+		t.flushKey(newItem.Key)
+		t.flushDue()
+	}
+
 	return t.set(newItem, true)
 }
 
 // Set will set an item on cache. If the key already exists the contents are overridden.
+// The expiry of an existing key is only replaced if newItem has an ExpireAt
+// or WithDefaultTTL gives it one.
+// Items that Add would reject with ErrInvalidItem are silently dropped.
 func (t *T) Set(newItem *Item) {
-	_ = t.set(newItem, false)
+	t.SetWithEvicted(newItem)
+}
+
+// SetWithEvicted is Set that also returns the first entry the call removed to
+// make room: the candidate that lost admission on leaving the window, the
+// victim it displaced from the slru, an entry evicted for the byte budget or
+// newItem itself if it was rejected. didEvict is false if nothing was
+// removed. The returned item is a copy, so a write-back cache can flush it
+// after the call. Other entries evicted by the same call, e.g. to fit a
+// large value in the byte budget, are only reported to OnEvict.
+func (t *T) SetWithEvicted(newItem *Item) (evicted *Item, didEvict bool) {
+	t.didEvict = false
+	if t.coalesce != nil {
+		t.coalesce.put(newItem, t.opts.clock.Now())
+		t.flushDue()
+	} else {
+		_ = t.set(newItem, false)
+	}
+
+	if !t.didEvict {
+		return nil, false
+	}
+	item := t.evicted
+	t.evicted = Item{}
+	return &item, true
 }
 
 func (t *T) set(newItem *Item, failIfKeyAlreadyExists bool) error {
+	return t.store(newItem, failIfKeyAlreadyExists, false)
+}
+
+// store is set; with force a new item skips the window and admission and
+// goes straight to the probation segment, see Commit.
+func (t *T) store(newItem *Item, failIfKeyAlreadyExists, force bool) error {
+	t.mustNotRange()
+
+	if err := t.validate(newItem); err != nil {
+		return err
+	}
+
 	if e, ok := t.data[newItem.Key]; ok {
 		if failIfKeyAlreadyExists {
 			return ErrKeyAlreadyExists
@@ -148,44 +665,302 @@ func (t *T) set(newItem *Item, failIfKeyAlreadyExists bool) error {
 		// Key is already in our cache.
 		// `Set` will act as a `Get` for list movements
 		item := e.Value.(*Item)
+		if t.opts.onOverwrite != nil {
+			t.opts.onOverwrite(item.Key, item.Value, newItem.Value)
+		}
+		if item.OnEvictReason != nil && t.opts.callbackMode != CallbackCacheOnly {
+			old := item.Value
+			t.callback(func() { item.OnEvictReason(item.Key, old, ReasonReplaced) })
+		}
 		item.Value = newItem.Value
+		t.noteType(item.Value)
+		if expireAt := t.expireAt(newItem); !expireAt.IsZero() {
+			item.ExpireAt = expireAt
+		}
+		t.untag(item)
+		item.Tags = newItem.Tags
+		t.tag(item)
+		item.Version++
 		t.countSketch.add(item.keyh)
+		if t.distinct != nil {
+			t.distinct.add(item.keyh)
+		}
+		item.Cost = newItem.Cost
+		item.SlidingTTL = newItem.SlidingTTL
+		size := t.itemSize(item)
+		t.bytes += size - item.size
+		item.size = size
 
-		if item.listid == 0 {
-			t.lru.get(e)
-		} else {
-			t.slru.get(e)
+		t.move(e)
+		if t.opts.onHit != nil {
+			t.opts.onHit(item.Key)
 		}
+		t.vetoes = t.vetoes[:0]
+		t.shrink(item.Key)
 
 		return nil
 	}
 
-	newItem.keyh = xxhash.Sum64String(newItem.Key)
+	t.vetoes = t.vetoes[:0]
+
+	newItem.keyh = t.hash(newItem.Key)
+	newItem.Version = 1
+	if t.distinct != nil {
+		t.distinct.add(newItem.keyh)
+	}
+	newItem.CreatedAt = t.opts.clock.Now()
+	newItem.ExpireAt = t.expireAt(newItem)
+	if t.opts.maxAge > 0 {
+		newItem.staleAt = newItem.CreatedAt.Add(t.opts.maxAge)
+	}
+
+	newItem.size = t.itemSize(newItem)
+	if t.opts.maxBytes > 0 && !t.fits(newItem.size) {
+		t.trace(eventEvict, newItem.Key, reasonRejected)
+		t.rejections++
+		t.noteEvicted(newItem)
+		t.onEvict(newItem, ReasonRejected)
+		return nil
+	}
+	t.bytes += newItem.size
+	t.tag(newItem)
+
+	if force {
+		t.place(newItem)
+	} else {
+		t.insert(newItem)
+	}
+	t.noteType(newItem.Value)
+	t.shrink(newItem.Key)
+	t.checkFull()
+
+	return nil
+}
 
+// insert adds a new item to the window, which may push another item through
+// admission into the slru.
+func (t *T) insert(newItem *Item) {
 	oldItem, evicted := t.lru.add(newItem)
 	if !evicted {
-		return nil
+		return
+	}
+
+	if !t.slru.full() {
+		t.admit(oldItem, nil)
+		return
 	}
 
 	// estimate count of what will be evicted from slru
-	victim := t.slru.victim()
+	victim := t.victim()
 	if victim == nil {
-		t.slru.add(oldItem)
-		return nil
+		// Everything in the slru is pinned or protected.
+		if t.canEvict(oldItem) {
+			t.discard(oldItem)
+		} else {
+			t.admit(oldItem, nil)
+		}
+		return
 	}
 
-	if !t.bouncer.allow(oldItem.keyh) {
-		t.onEvict(oldItem)
-		return nil
+	if !t.canEvict(oldItem) || t.bypass || t.opts.admission == AdmitAlways {
+		t.admit(oldItem, victim)
+		return
+	}
+	if policy := t.opts.admission; policy != nil && policy != AdmitTinyLFU {
+		if policy.Admit(oldItem.keyh, victim.Value.(*Item).keyh, t.countSketch) {
+			t.lastAdmit = t.opts.clock.Now()
+			t.admit(oldItem, victim)
+		} else {
+			t.discard(oldItem)
+		}
+		return
+	}
+
+	grace := t.w < t.grace
+	if !t.bouncer.allow(oldItem.keyh) && !grace {
+		t.discard(oldItem)
+		return
 	}
 
-	victimCount := t.countSketch.estimate(victim.keyh)
+	victimCount := t.countSketch.estimate(victim.Value.(*Item).keyh)
 	itemCount := t.countSketch.estimate(oldItem.keyh)
+	if t.guard != nil && t.guard.scanning {
+		victimCount += scanGuardMargin
+	}
+
+	if t.opts.weighCost {
+		// Compare the frequency per unit of cost.
+		itemCount, victimCount = weigh(itemCount, oldItem, victimCount, victim.Value.(*Item))
+	}
 
-	if itemCount > victimCount {
-		t.slru.add(oldItem)
+	if itemCount > victimCount || grace && itemCount == victimCount {
+		t.lastAdmit = t.opts.clock.Now()
+		t.admit(oldItem, victim)
 	} else {
-		t.onEvict(oldItem)
+		t.discard(oldItem)
+	}
+}
+
+// weigh compares the frequency per unit of cost of a candidate and a victim
+// and returns counts ordered the same way, 1 and 0, 0 and 1 or a tie, for the
+// admission comparison. Costs below 1 count as 1.
+func weigh(itemCount byte, item *Item, victimCount byte, victim *Item) (byte, byte) {
+	itemCost, victimCost := max(item.size, 1), max(victim.size, 1)
+	a := float64(itemCount) * float64(victimCost)
+	b := float64(victimCount) * float64(itemCost)
+	switch {
+	case a > b:
+		return 1, 0
+	case a < b:
+		return 0, 1
+	}
+	return 0, 0
+}
+
+// admit adds newItem to the slru, evicting victim unless it is nil.
+func (t *T) admit(newItem *Item, victim *list.Element) {
+	if victim == nil {
+		t.slru.add(newItem, nil)
+		t.trace(eventAdmit, newItem.Key, "")
+		return
+	}
+
+	// Copy the victim since add may reuse its item for newItem.
+	evicted := *victim.Value.(*Item)
+	t.trace(eventEvict, evicted.Key, reasonCapacity)
+	t.bytes -= evicted.size
+	t.untag(&evicted)
+	t.slru.add(newItem, victim)
+	t.trace(eventAdmit, newItem.Key, "")
+	t.countEviction(&evicted)
+	t.onEvict(&evicted, ReasonCapacity)
+}
+
+// discard drops an item that lost admission.
+func (t *T) discard(item *Item) {
+	t.trace(eventEvict, item.Key, reasonRejected)
+	t.bytes -= item.size
+	t.untag(item)
+	t.rejections++
+	t.countEviction(item)
+	t.onEvict(item, ReasonRejected)
+}
+
+// noteEvicted keeps the first entry removed to make room during a Set, see
+// SetWithEvicted.
+func (t *T) noteEvicted(item *Item) {
+	if !t.didEvict {
+		t.didEvict = true
+		t.evicted = *item
+	}
+}
+
+// countEviction counts an entry removed to make room, see Stats.TotalEvictions.
+func (t *T) countEviction(item *Item) {
+	t.noteEvicted(item)
+	t.evictions++
+	t.evictionsSinceReset++
+	if item.listid == 0 {
+		t.windowEvictions++
+	} else {
+		t.mainEvictions++
+	}
+}
+
+// victim returns the slru element to evict in favour of a new item, or nil if
+// no entry can be evicted.
+func (t *T) victim() *list.Element {
+	v := t.evictable(t.slru.one.Back())
+	if v == nil {
+		return t.evictable(t.slru.two.Back())
+	}
+	if !t.opts.expiryAware {
+		return v
+	}
+
+	// Among the least recently used entries with the same estimated
+	// frequency prefer the one that expires first.
+	best := v.Value.(*Item)
+	count := t.countSketch.estimate(best.keyh)
+
+	e := t.evictable(v.Prev())
+	for i := 1; i < expiryAwareCandidates && e != nil; i++ {
+		item := e.Value.(*Item)
+		if t.countSketch.estimate(item.keyh) == count && expiresBefore(item, best) {
+			v, best = e, item
+		}
+		e = t.evictable(e.Prev())
+	}
+
+	return v
+}
+
+// evictable returns the first element starting from e and moving towards the
+// front of its list that may be evicted, or nil.
+func (t *T) evictable(e *list.Element) *list.Element {
+	for ; e != nil; e = e.Prev() {
+		if t.canEvict(e.Value.(*Item)) {
+			return e
+		}
+	}
+	return nil
+}
+
+// canEvict reports whether item may be evicted for capacity.
+func (t *T) canEvict(item *Item) bool {
+	if item.pinned {
+		return false
+	}
+	if !item.ProtectUntil.IsZero() && t.opts.clock.Now().Before(item.ProtectUntil) {
+		return false
+	}
+	if t.opts.veto != nil {
+		return !t.vetoed(item)
+	}
+	return true
+}
+
+// vetoed consults the eviction veto, remembering its answer for the rest of
+// the current insert.
+func (t *T) vetoed(item *Item) bool {
+	for _, key := range t.vetoes {
+		if key == item.Key {
+			return true
+		}
+	}
+	if len(t.vetoes) < maxEvictionVetoes && t.opts.veto(item) {
+		t.vetoes = append(t.vetoes, item.Key)
+		return true
+	}
+	return false
+}
+
+// expiresBefore reports whether a expires before b. Items without ExpireAt
+// never expire.
+func expiresBefore(a, b *Item) bool {
+	if a.ExpireAt.IsZero() {
+		return false
+	}
+	return b.ExpireAt.IsZero() || a.ExpireAt.Before(b.ExpireAt)
+}
+
+// noteType records the type of the first value stored when
+// WithTypeEnforcement is used. It is called once the value is in the cache,
+// so that a value rejected for another reason doesn't fix the type.
+func (t *T) noteType(value interface{}) {
+	if t.opts.enforceType && t.valueType == nil {
+		t.valueType = reflect.TypeOf(value)
+	}
+}
+
+// validate checks newItem against the cache configuration.
+func (t *T) validate(newItem *Item) error {
+	if t.valueType != nil && reflect.TypeOf(newItem.Value) != t.valueType {
+		return ErrInvalidItem
+	}
+
+	if t.opts.maxValueBytes > 0 && t.sizeOf(newItem.Value) > t.opts.maxValueBytes {
+		return ErrInvalidItem
 	}
 
 	return nil
@@ -193,14 +968,79 @@ func (t *T) set(newItem *Item, failIfKeyAlreadyExists bool) error {
 
 // Del remove a key from cache if exists.
 func (t *T) Del(key string) {
+	if t.coalesce != nil {
+		t.coalesce.take(key)
+	}
+
 	if val, ok := t.data[key]; ok {
 		t.del(val)
 	}
 }
 
+// GetAndDelete removes a key from cache and returns its value. Like Del it
+// fires OnEvict and discards a pending coalesced write, whose value is the one
+// returned. It returns false if the key is missing or expired.
+func (t *T) GetAndDelete(key string) (interface{}, bool) {
+	var pending *Item
+	if t.coalesce != nil {
+		pending, _ = t.coalesce.take(key)
+	}
+
+	var value interface{}
+	var ok bool
+	if val, found := t.data[key]; found {
+		item := val.Value.(*Item)
+		if item.expired(t.opts.clock.Now()) {
+			t.expire(val)
+		} else {
+			value, ok = item.Value, true
+			t.del(val)
+		}
+	}
+	if pending != nil {
+		value, ok = pending.Value, true
+	}
+
+	return value, ok
+}
+
 func (t *T) del(val *list.Element) {
+	item := t.remove(val)
+	t.trace(eventEvict, item.Key, reasonDeleted)
+	t.onEvict(item, ReasonDeleted)
+	t.checkFull()
+}
+
+// evict removes an element for capacity.
+func (t *T) evict(val *list.Element) {
+	item := t.remove(val)
+	t.trace(eventEvict, item.Key, reasonCapacity)
+	t.countEviction(item)
+	t.onEvict(item, ReasonCapacity)
+	t.checkFull()
+}
+
+// expire removes an expired element.
+func (t *T) expire(val *list.Element) {
+	item := t.remove(val)
+	t.trace(eventExpire, item.Key, reasonExpired)
+	t.expirations++
+	t.notify(item, ReasonExpired, t.opts.onExpire)
+	t.checkFull()
+}
+
+// remove unlinks an element from the cache without firing callbacks.
+func (t *T) remove(val *list.Element) *Item {
+	t.mustNotRange()
+
 	item := val.Value.(*Item)
 	delete(t.data, item.Key)
+	t.bytes -= item.size
+	t.untag(item)
+	if item.pinned {
+		item.pinned = false
+		t.pinned--
+	}
 
 	if item.listid == 0 {
 		t.lru.Remove(val)
@@ -208,7 +1048,7 @@ func (t *T) del(val *list.Element) {
 		t.slru.Remove(val)
 	}
 
-	t.onEvict(item)
+	return item
 }
 
 //------------------------------------------------------------------------------
@@ -218,18 +1058,30 @@ var _ LFU = (*SyncT)(nil)
 type SyncT struct {
 	mu sync.RWMutex
 	t  *T
+
+	janitor *janitor
+
+	// flights holds the loads in progress of Do.
+	flightMu sync.Mutex
+	flights  map[string]*flight
 }
 
-func NewSync(size int, samples int) *SyncT {
-	return &SyncT{
-		t: New(size, samples),
+func NewSync(size int, samples int, opts ...Option) *SyncT {
+	t := &SyncT{
+		t: New(size, samples, opts...),
 	}
+	if interval := t.t.opts.janitor; interval > 0 {
+		t.janitor = startJanitor(t, interval)
+	}
+	return t
 }
 
+// Get takes the write lock: a Get updates the frequency sketch and the
+// recency order.
 func (t *SyncT) Get(key string) (interface{}, bool) {
-	t.mu.RLock()
+	t.mu.Lock()
 	val, ok := t.t.Get(key)
-	t.mu.RUnlock()
+	t.mu.Unlock()
 
 	return val, ok
 }
@@ -240,6 +1092,14 @@ func (t *SyncT) Set(item *Item) {
 	t.mu.Unlock()
 }
 
+func (t *SyncT) SetWithEvicted(item *Item) (*Item, bool) {
+	t.mu.Lock()
+	evicted, ok := t.t.SetWithEvicted(item)
+	t.mu.Unlock()
+
+	return evicted, ok
+}
+
 func (t *SyncT) Add(item *Item) error {
 	t.mu.Lock()
 	err := t.t.Add(item)
@@ -253,3 +1113,75 @@ func (t *SyncT) Del(key string) {
 	t.t.Del(key)
 	t.mu.Unlock()
 }
+
+func (t *SyncT) GetResult(key string) Result {
+	t.mu.Lock()
+	r := t.t.GetResult(key)
+	t.mu.Unlock()
+
+	return r
+}
+
+func (t *SyncT) Peek(key string) (interface{}, bool) {
+	t.rlock()
+	val, ok := t.t.Peek(key)
+	t.runlock()
+
+	return val, ok
+}
+
+func (t *SyncT) PeekResult(key string) Result {
+	t.rlock()
+	r := t.t.PeekResult(key)
+	t.runlock()
+
+	return r
+}
+
+func (t *SyncT) Epoch() uint64 {
+	t.mu.RLock()
+	n := t.t.Epoch()
+	t.mu.RUnlock()
+
+	return n
+}
+
+func (t *SyncT) Frequency(key string) uint8 {
+	t.mu.RLock()
+	n := t.t.Frequency(key)
+	t.mu.RUnlock()
+
+	return n
+}
+
+func (t *SyncT) DistinctKeysSeen() uint64 {
+	t.mu.RLock()
+	n := t.t.DistinctKeysSeen()
+	t.mu.RUnlock()
+
+	return n
+}
+
+func (t *SyncT) WorkingSetEstimate() int {
+	t.mu.RLock()
+	n := t.t.WorkingSetEstimate()
+	t.mu.RUnlock()
+
+	return n
+}
+
+func (t *SyncT) GetAndDelete(key string) (interface{}, bool) {
+	t.mu.Lock()
+	val, ok := t.t.GetAndDelete(key)
+	t.mu.Unlock()
+
+	return val, ok
+}
+
+func (t *SyncT) DoorkeeperDensity() float64 {
+	t.mu.RLock()
+	d := t.t.DoorkeeperDensity()
+	t.mu.RUnlock()
+
+	return d
+}
@@ -1,11 +1,21 @@
 package tinylfu_test
 
 import (
+	"bytes"
 	"context"
 	cryptorand "crypto/rand"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -176,3 +186,3466 @@ func TestAddAlreadyInCache(t *testing.T) {
 		t.Errorf("c.Get(foo)=%q, want %q", val, "baz")
 	}
 }
+
+func BenchmarkGetTailLatency(b *testing.B) {
+	const size = 1e5
+	const samples = int(size)
+
+	run := func(b *testing.B, opts ...tinylfu.Option) {
+		cache := tinylfu.New(size, samples, opts...)
+		keys := make([]string, size)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key-%d", i)
+			cache.Set(&tinylfu.Item{Key: keys[i], Value: i})
+		}
+
+		durations := make([]time.Duration, b.N)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			start := time.Now()
+			cache.Get(keys[i%len(keys)])
+			durations[i] = time.Since(start)
+		}
+		b.StopTimer()
+
+		// Every samples-th Get triggers a reset.
+		var resetMax time.Duration
+		for i := samples - 1; i < len(durations); i += samples {
+			if durations[i] > resetMax {
+				resetMax = durations[i]
+			}
+		}
+
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		b.ReportMetric(float64(durations[len(durations)*99/100]), "p99-ns")
+		b.ReportMetric(float64(resetMax), "reset-max-ns")
+	}
+
+	b.Run("sync", func(b *testing.B) { run(b) })
+	b.Run("incremental", func(b *testing.B) { run(b, tinylfu.WithIncrementalReset(64)) })
+}
+
+func TestGetResult(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+
+	r := cache.GetResult("missing")
+	require.False(t, r.Found())
+	require.False(t, r.Expired())
+	require.Nil(t, r.Value())
+	require.Zero(t, r.Version())
+	require.Zero(t, r.Age())
+	require.Equal(t, uint8(1), r.Frequency())
+	_, ok := r.String()
+	require.False(t, ok)
+	_, ok = r.Bytes()
+	require.False(t, ok)
+
+	cache.Set(&tinylfu.Item{Key: "str", Value: "foo"})
+	cache.Set(&tinylfu.Item{Key: "str", Value: "bar"})
+	cache.Set(&tinylfu.Item{Key: "bytes", Value: []byte("baz")})
+
+	r = cache.GetResult("str")
+	require.True(t, r.Found())
+	require.False(t, r.Expired())
+	require.Equal(t, "bar", r.Value())
+	require.Equal(t, uint64(2), r.Version())
+	require.True(t, r.Age() > 0)
+	require.Equal(t, uint8(2), r.Frequency())
+	s, ok := r.String()
+	require.True(t, ok)
+	require.Equal(t, "bar", s)
+	_, ok = r.Bytes()
+	require.False(t, ok)
+
+	r = cache.GetResult("bytes")
+	require.True(t, r.Found())
+	require.Equal(t, uint64(1), r.Version())
+	b, ok := r.Bytes()
+	require.True(t, ok)
+	require.Equal(t, []byte("baz"), b)
+
+	cache.Set(&tinylfu.Item{
+		Key:      "expired",
+		Value:    "old",
+		ExpireAt: time.Now().Add(-time.Second),
+	})
+
+	r = cache.PeekResult("expired")
+	require.False(t, r.Found())
+	require.True(t, r.Expired())
+
+	r = cache.GetResult("expired")
+	require.False(t, r.Found())
+	require.True(t, r.Expired())
+
+	r = cache.GetResult("expired")
+	require.False(t, r.Expired())
+}
+
+func TestPeek(t *testing.T) {
+	clock := newManualClock()
+
+	resident := func(peek bool) []string {
+		cache := tinylfu.New(100, 1000, tinylfu.WithClock(clock))
+		for _, key := range zipfTrace(5000, 500) {
+			if peek {
+				for i := 0; i < 3; i++ {
+					cache.Peek("key-499")
+				}
+			}
+			if _, ok := cache.Get(key); !ok {
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+		}
+
+		var keys []string
+		for key := range cache.All() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	require.Equal(t, resident(false), resident(true))
+
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "x", ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(2 * time.Second)
+
+	value, ok := cache.Peek("foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", value)
+	_, ok = cache.Peek("expired")
+	require.False(t, ok)
+	_, ok = cache.Peek("missing")
+	require.False(t, ok)
+
+	require.Equal(t, 2, cache.Len())
+	require.Zero(t, cache.Stats().Hits+cache.Stats().Misses)
+	require.Zero(t, cache.PeekResult("foo").Frequency())
+}
+
+func TestPeekResult(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+
+	for i := 0; i < 3; i++ {
+		r := cache.PeekResult("foo")
+		require.True(t, r.Found())
+		require.Equal(t, "bar", r.Value())
+		require.Zero(t, r.Frequency())
+	}
+}
+
+func TestExpiryAwareEviction(t *testing.T) {
+	run := func(opts ...tinylfu.Option) *tinylfu.T {
+		// 1 window slot and 9 slru slots.
+		cache := tinylfu.New(10, 10000, opts...)
+
+		now := time.Now()
+		cache.Set(&tinylfu.Item{Key: "late", Value: "late", ExpireAt: now.Add(2 * time.Hour)})
+		cache.Set(&tinylfu.Item{Key: "soon", Value: "soon", ExpireAt: now.Add(time.Hour)})
+		for i := 0; i < 8; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			cache.Set(&tinylfu.Item{Key: key, Value: key})
+		}
+
+		// Make "hot" frequent and let it pass the doorkeeper once so that it
+		// wins admission against the cold entries on its second attempt.
+		for i := 0; i < 3; i++ {
+			cache.Get("hot")
+		}
+		for _, key := range []string{"hot", "filler-1", "hot", "filler-2"} {
+			cache.Set(&tinylfu.Item{Key: key, Value: key})
+		}
+		require.True(t, cache.PeekResult("hot").Found())
+
+		return cache
+	}
+
+	cache := run()
+	require.False(t, cache.PeekResult("late").Found())
+	require.True(t, cache.PeekResult("soon").Found())
+
+	cache = run(tinylfu.WithExpiryAwareEviction())
+	require.True(t, cache.PeekResult("late").Found())
+	require.False(t, cache.PeekResult("soon").Found())
+}
+
+type manualClock struct {
+	now time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1e9, 0)}
+}
+
+func (c *manualClock) Now() time.Time { return c.now }
+
+func (c *manualClock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestClockExpiryBoundary(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+
+	expireAt := clock.Now().Add(time.Minute)
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar", ExpireAt: expireAt})
+
+	clock.Add(time.Minute)
+	require.Equal(t, expireAt, clock.Now())
+	_, ok := cache.Get("foo")
+	require.True(t, ok, "live at ExpireAt")
+
+	clock.Add(time.Nanosecond)
+	_, ok = cache.Get("foo")
+	require.False(t, ok, "expired one nanosecond after ExpireAt")
+}
+
+func TestOnExpire(t *testing.T) {
+	clock := newManualClock()
+
+	var expired []string
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithClock(clock),
+		tinylfu.WithCallbackMode(tinylfu.CallbackCacheOnly),
+		tinylfu.WithOnExpire(func(item *tinylfu.Item) {
+			expired = append(expired, item.Key)
+		}),
+	)
+
+	var evicted int
+	cache.Set(&tinylfu.Item{
+		Key:      "foo",
+		Value:    "bar",
+		ExpireAt: clock.Now().Add(time.Second),
+		OnEvict:  func() { evicted++ },
+	})
+
+	_, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Empty(t, expired)
+
+	clock.Add(2 * time.Second)
+
+	_, ok = cache.Get("foo")
+	require.False(t, ok)
+	_, ok = cache.Get("foo")
+	require.False(t, ok)
+
+	require.Equal(t, []string{"foo"}, expired)
+	require.Zero(t, evicted)
+}
+
+func TestMaxAge(t *testing.T) {
+	clock := newManualClock()
+
+	var expired []string
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithClock(clock),
+		tinylfu.WithMaxAge(time.Hour),
+		tinylfu.WithOnExpire(func(item *tinylfu.Item) {
+			expired = append(expired, item.Key)
+		}),
+	)
+
+	cache.Set(&tinylfu.Item{Key: "forever", Value: "a"})
+	cache.Set(&tinylfu.Item{Key: "long", Value: "b", ExpireAt: clock.Now().Add(24 * time.Hour)})
+	cache.Set(&tinylfu.Item{Key: "short", Value: "c", ExpireAt: clock.Now().Add(time.Minute)})
+
+	clock.Add(30 * time.Minute)
+	cache.Set(&tinylfu.Item{Key: "forever", Value: "a2"})
+	for _, key := range []string{"forever", "long"} {
+		_, ok := cache.Get(key)
+		require.True(t, ok, key)
+	}
+	_, ok := cache.Get("short")
+	require.False(t, ok)
+
+	clock.Add(31 * time.Minute)
+	for _, key := range []string{"forever", "long"} {
+		_, ok := cache.Get(key)
+		require.False(t, ok, key)
+	}
+	require.Equal(t, []string{"short", "forever", "long"}, expired)
+	require.Zero(t, cache.Len())
+}
+
+func TestCallbackMode(t *testing.T) {
+	tests := []struct {
+		mode      tinylfu.CallbackMode
+		wantItem  []string
+		wantCache []string
+	}{
+		{tinylfu.CallbackBoth, []string{"del", "expire"}, []string{"evict:del", "expire:expire"}},
+		{tinylfu.CallbackItemOnly, []string{"del", "expire"}, nil},
+		{tinylfu.CallbackCacheOnly, nil, []string{"evict:del", "expire:expire"}},
+	}
+
+	for _, test := range tests {
+		clock := newManualClock()
+
+		var calls, itemCalls, cacheCalls []string
+		cache := tinylfu.New(100, 10000,
+			tinylfu.WithClock(clock),
+			tinylfu.WithCallbackMode(test.mode),
+			tinylfu.WithOnEvict(func(item *tinylfu.Item) {
+				calls = append(calls, "cache")
+				cacheCalls = append(cacheCalls, "evict:"+item.Key)
+			}),
+			tinylfu.WithOnExpire(func(item *tinylfu.Item) {
+				calls = append(calls, "cache")
+				cacheCalls = append(cacheCalls, "expire:"+item.Key)
+			}),
+		)
+
+		for _, key := range []string{"del", "expire"} {
+			key := key
+			cache.Set(&tinylfu.Item{
+				Key:      key,
+				Value:    key,
+				ExpireAt: clock.Now().Add(time.Second),
+				OnEvict: func() {
+					calls = append(calls, "item")
+					itemCalls = append(itemCalls, key)
+				},
+			})
+		}
+
+		cache.Del("del")
+		clock.Add(2 * time.Second)
+		_, ok := cache.Get("expire")
+		require.False(t, ok)
+
+		require.Equal(t, test.wantItem, itemCalls, "mode %d", test.mode)
+		require.Equal(t, test.wantCache, cacheCalls, "mode %d", test.mode)
+		if test.mode == tinylfu.CallbackBoth {
+			require.Equal(t, []string{"item", "cache", "item", "cache"}, calls)
+		}
+	}
+}
+
+func TestCallbackRecovery(t *testing.T) {
+	errClose := errors.New("close failed")
+
+	var cacheCalls int
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithCallbackRecovery(),
+		tinylfu.WithOnEvict(func(item *tinylfu.Item) { cacheCalls++ }),
+	)
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", OnEvict: func() { panic(errClose) }})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "b", OnEvict: func() { panic("oops") }})
+	cache.Set(&tinylfu.Item{Key: "c", Value: "c", OnEvict: func() {}})
+
+	cache.Del("a")
+	stats := cache.Stats()
+	require.Equal(t, uint64(1), stats.CallbackErrors)
+	require.Equal(t, errClose, stats.LastCallbackError)
+
+	cache.Del("b")
+	cache.Del("c")
+	stats = cache.Stats()
+	require.Equal(t, uint64(2), stats.CallbackErrors)
+	require.EqualError(t, stats.LastCallbackError, "callback panic: oops")
+	require.Equal(t, 3, cacheCalls)
+
+	// Without the option panics propagate.
+	cache = tinylfu.New(100, 10000)
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", OnEvict: func() { panic(errClose) }})
+	require.PanicsWithValue(t, errClose, func() { cache.Del("a") })
+}
+
+func TestOnFullOnDrain(t *testing.T) {
+	var events []string
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithOnFull(func() { events = append(events, "full") }),
+		tinylfu.WithOnDrain(func() { events = append(events, "drain") }),
+	)
+
+	for i := 0; i < 99; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	require.Empty(t, events)
+
+	cache.Set(&tinylfu.Item{Key: "99", Value: 99})
+	require.Equal(t, []string{"full"}, events)
+	for i := 100; i < 150; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	require.Equal(t, []string{"full"}, events)
+
+	cache.Del("149")
+	require.Equal(t, []string{"full", "drain"}, events)
+	cache.Del("148")
+	cache.Del("147")
+	require.Equal(t, []string{"full", "drain"}, events)
+
+	for i := 0; i < 3; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprintf("new-%d", i), Value: i})
+	}
+	cache.Clear()
+	require.Equal(t, []string{"full", "drain", "full", "drain"}, events)
+}
+
+func TestInvalidateTag(t *testing.T) {
+	cache := tinylfu.New(1000, 10000)
+
+	var evicted []string
+	set := func(key string, tags ...string) {
+		cache.Set(&tinylfu.Item{
+			Key:     key,
+			Value:   key,
+			Tags:    tags,
+			OnEvict: func() { evicted = append(evicted, key) },
+		})
+	}
+	set("a", "user:1")
+	set("b", "user:1", "user:2")
+	set("c", "user:2")
+	set("d")
+
+	require.Equal(t, 2, cache.InvalidateTag("user:1"))
+	require.ElementsMatch(t, []string{"a", "b"}, evicted)
+	for key, want := range map[string]bool{"a": false, "b": false, "c": true, "d": true} {
+		_, ok := cache.Peek(key)
+		require.Equal(t, want, ok, key)
+	}
+
+	require.Equal(t, 0, cache.InvalidateTag("user:1"))
+	require.Equal(t, 1, cache.InvalidateTag("user:2"))
+
+	// Setting a resident key replaces its tags.
+	set("d", "user:3")
+	set("d", "user:4")
+	require.Equal(t, 0, cache.InvalidateTag("user:3"))
+	require.Equal(t, 1, cache.InvalidateTag("user:4"))
+	require.Equal(t, 0, cache.Len())
+}
+
+func TestOnEvictReason(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithDoorkeeper(&alwaysAllow{}))
+
+	reasons := make(map[string][]tinylfu.EvictionReason)
+	var values []interface{}
+	set := func(key string, value interface{}, expireAt time.Time) {
+		cache.Set(&tinylfu.Item{
+			Key:      key,
+			Value:    value,
+			ExpireAt: expireAt,
+			OnEvictReason: func(key string, value interface{}, reason tinylfu.EvictionReason) {
+				reasons[key] = append(reasons[key], reason)
+				values = append(values, value)
+			},
+		})
+	}
+
+	set("a", 1, time.Time{})
+	set("a", 2, time.Time{})
+	require.Equal(t, []tinylfu.EvictionReason{tinylfu.ReasonReplaced}, reasons["a"])
+	require.Equal(t, []interface{}{1}, values)
+	cache.Del("a")
+	require.Equal(t, []tinylfu.EvictionReason{tinylfu.ReasonReplaced, tinylfu.ReasonDeleted}, reasons["a"])
+	require.Equal(t, []interface{}{1, 2}, values)
+
+	set("ttl", 1, clock.Now().Add(time.Second))
+	clock.Add(2 * time.Second)
+	_, ok := cache.Get("ttl")
+	require.False(t, ok)
+	require.Equal(t, []tinylfu.EvictionReason{tinylfu.ReasonExpired}, reasons["ttl"])
+
+	// Keys seen once lose admission against the residents.
+	for i := 0; i < 100; i++ {
+		set(fmt.Sprint(i), i, time.Time{})
+	}
+	set("cold", 0, time.Time{})
+	set("push", 0, time.Time{})
+	require.Equal(t, []tinylfu.EvictionReason{tinylfu.ReasonRejected}, reasons["cold"])
+
+	// A hot key wins admission and evicts a victim.
+	for i := 0; i < 5; i++ {
+		cache.Get("hot")
+	}
+	set("hot", 0, time.Time{})
+	set("push2", 0, time.Time{})
+	_, ok = cache.Peek("hot")
+	require.True(t, ok)
+	var capacity int
+	for _, rs := range reasons {
+		for _, r := range rs {
+			if r == tinylfu.ReasonCapacity {
+				capacity++
+			}
+		}
+	}
+	require.Equal(t, 1, capacity)
+	require.Equal(t, "capacity", tinylfu.ReasonCapacity.String())
+}
+
+func TestSetWithEvicted(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithDoorkeeper(&alwaysAllow{}))
+
+	for i := 0; i < 100; i++ {
+		evicted, ok := cache.SetWithEvicted(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+		require.False(t, ok)
+		require.Nil(t, evicted)
+	}
+
+	// "100" is pushed out of the window by "101" and loses admission.
+	cache.Set(&tinylfu.Item{Key: "100", Value: 100})
+	evicted, ok := cache.SetWithEvicted(&tinylfu.Item{Key: "101", Value: 101})
+	require.True(t, ok)
+	require.Equal(t, "100", evicted.Key)
+	require.Equal(t, 100, evicted.Value)
+
+	// A hot candidate wins and the victim is returned instead.
+	for i := 0; i < 5; i++ {
+		cache.Get("101")
+	}
+	evicted, ok = cache.SetWithEvicted(&tinylfu.Item{Key: "102", Value: 102})
+	require.True(t, ok)
+	require.NotEqual(t, "101", evicted.Key)
+	_, resident := cache.Peek(evicted.Key)
+	require.False(t, resident)
+	_, resident = cache.Peek("101")
+	require.True(t, resident)
+
+	// Replacing a value evicts nothing.
+	evicted, ok = cache.SetWithEvicted(&tinylfu.Item{Key: "102", Value: 0})
+	require.False(t, ok)
+	require.Nil(t, evicted)
+}
+
+func TestSetOnEvict(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	require.False(t, cache.SetOnEvict("foo", func() {}))
+
+	var evicted []string
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+	cache.Set(&tinylfu.Item{
+		Key:     "baz",
+		Value:   "qux",
+		OnEvict: func() { evicted = append(evicted, "baz-old") },
+	})
+	require.True(t, cache.SetOnEvict("foo", func() { evicted = append(evicted, "foo") }))
+	require.True(t, cache.SetOnEvict("baz", func() { evicted = append(evicted, "baz") }))
+
+	cache.Del("foo")
+	cache.Del("baz")
+	require.Equal(t, []string{"foo", "baz"}, evicted)
+
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "x", ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(2 * time.Second)
+	require.False(t, cache.SetOnEvict("expired", func() {}))
+}
+
+func TestClear(t *testing.T) {
+	cache := tinylfu.New(100, 10000, tinylfu.WithMaxBytes(1000))
+
+	var evicted int
+	for i := 0; i < 50; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: "value", OnEvict: func() { evicted++ }})
+	}
+	cache.Pin("1")
+
+	cache.Clear()
+	require.Zero(t, evicted)
+	require.Equal(t, 0, cache.Stats().Size)
+	require.Equal(t, 0, cache.Stats().Pinned)
+	require.Equal(t, int64(0), cache.Stats().Bytes)
+	_, ok := cache.Get("1")
+	require.False(t, ok)
+
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+	val, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", val)
+	require.Empty(t, cache.OrphanedKeys())
+}
+
+func TestReset(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithKeyEstimates())
+
+	var evicted []string
+	for _, key := range []string{"a", "b", "c"} {
+		key := key
+		cache.Set(&tinylfu.Item{Key: key, Value: key, OnEvict: func() { evicted = append(evicted, key) }})
+	}
+	cache.Get("a")
+	require.Equal(t, 3, cache.Len())
+
+	cache.Reset()
+	require.Zero(t, cache.Len())
+	require.ElementsMatch(t, []string{"a", "b", "c"}, evicted)
+	for _, key := range []string{"a", "b", "c"} {
+		_, ok := cache.Get(key)
+		require.False(t, ok)
+	}
+	require.Equal(t, uint8(1), cache.PeekResult("a").Frequency())
+	require.Equal(t, uint64(3), cache.DistinctKeysSeen())
+}
+
+func TestResetSketch(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+
+	var evicted []string
+	for _, key := range []string{"a", "b", "c"} {
+		key := key
+		cache.Set(&tinylfu.Item{Key: key, Value: key, OnEvict: func() { evicted = append(evicted, key) }})
+	}
+	for i := 0; i < 5; i++ {
+		cache.Get("a")
+	}
+	require.Equal(t, uint8(5), cache.Frequency("a"))
+	epoch := cache.Epoch()
+
+	cache.ResetSketch()
+	require.Equal(t, 3, cache.Len())
+	require.Empty(t, evicted)
+	for _, key := range []string{"a", "b", "c"} {
+		require.Zero(t, cache.Frequency(key), key)
+	}
+	require.Equal(t, epoch, cache.Epoch())
+
+	for _, key := range []string{"a", "b", "c"} {
+		value, ok := cache.Get(key)
+		require.True(t, ok)
+		require.Equal(t, key, value)
+		require.Equal(t, uint8(1), cache.Frequency(key), key)
+	}
+}
+
+// TestSyncGetRace hammers Get on shared keys from many goroutines. It is meant
+// to be run with -race: Get updates the sketch, the doorkeeper, the recency
+// order and removes expired entries.
+func TestSyncGetRace(t *testing.T) {
+	clock := newManualClock()
+	// Few samples so that the sketch is reset during the test.
+	cache := tinylfu.NewSync(100, 500, tinylfu.WithClock(clock))
+	for i := 0; i < 200; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i, ExpireAt: clock.Now().Add(time.Second)})
+	}
+	clock.Add(2 * time.Second)
+	for i := 0; i < 50; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprintf("live-%d", i), Value: i})
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				cache.Get(fmt.Sprint((g + i) % 200))
+				cache.Get(fmt.Sprintf("live-%d", i%50))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	require.Empty(t, cache.OrphanedKeys())
+	stats := cache.Stats()
+	require.Equal(t, uint64(16*2000*2), stats.Hits+stats.Misses)
+	require.NotZero(t, cache.Epoch())
+}
+
+func TestSetManyGetMany(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+	cache.SetMany([]*tinylfu.Item{
+		{Key: "a", Value: 1},
+		{Key: "b", Value: 2},
+		{Key: "a", Value: 3},
+	})
+
+	got := cache.GetMany([]string{"a", "b", "missing"})
+	require.Equal(t, map[string]interface{}{"a": 3, "b": 2}, got)
+	require.Equal(t, uint64(2), cache.Stats().Hits)
+	require.Equal(t, uint64(1), cache.Stats().Misses)
+
+	// Each batch runs under one lock acquisition, so a GetMany racing with
+	// SetMany never sees a mix of two batches.
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	batch := func(v int) []*tinylfu.Item {
+		items := make([]*tinylfu.Item, len(keys))
+		for i, key := range keys {
+			items[i] = &tinylfu.Item{Key: key, Value: v}
+		}
+		return items
+	}
+	cache.SetMany(batch(0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for v := 1; v <= 500; v++ {
+			cache.SetMany(batch(v))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		values := cache.GetMany(keys)
+		require.Len(t, values, len(keys))
+		for _, v := range values {
+			require.Equal(t, values["0"], v)
+		}
+	}
+}
+
+func TestDelManyDelPrefix(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+
+	var evicted []string
+	for _, key := range []string{"user:1", "user:2", "user:3", "users", "group:1", "group:2"} {
+		cache.Set(&tinylfu.Item{Key: key, Value: key, OnEvict: func() { evicted = append(evicted, key) }})
+	}
+
+	require.Equal(t, 3, cache.DelPrefix("user:"))
+	require.ElementsMatch(t, []string{"user:1", "user:2", "user:3"}, evicted)
+	require.ElementsMatch(t, []string{"users", "group:1", "group:2"}, cache.Keys())
+	require.Equal(t, 0, cache.DelPrefix("user:"))
+
+	cache.DelMany([]string{"group:1", "missing", "users"})
+	require.Equal(t, []string{"group:2"}, cache.Keys())
+	require.Len(t, evicted, 5)
+}
+
+func TestJanitor(t *testing.T) {
+	var mu sync.Mutex
+	var reasons []tinylfu.EvictionReason
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithJanitor(5*time.Millisecond))
+	defer cache.Stop()
+
+	for i := 0; i < 20; i++ {
+		cache.Set(&tinylfu.Item{
+			Key:      fmt.Sprint(i),
+			Value:    i,
+			ExpireAt: time.Now().Add(10 * time.Millisecond),
+			OnEvictReason: func(_ string, _ interface{}, reason tinylfu.EvictionReason) {
+				mu.Lock()
+				reasons = append(reasons, reason)
+				mu.Unlock()
+			},
+		})
+	}
+	require.Equal(t, 20, cache.Len())
+
+	require.Eventually(t, func() bool { return cache.Len() == 0 }, time.Second, time.Millisecond)
+	mu.Lock()
+	require.Len(t, reasons, 20)
+	for _, r := range reasons {
+		require.Equal(t, tinylfu.ReasonExpired, r)
+	}
+	mu.Unlock()
+	require.Equal(t, uint64(0), cache.Stats().Hits+cache.Stats().Misses)
+
+	cache.Stop()
+	cache.Stop()
+}
+
+// requireGoroutines waits for the number of goroutines to drop to n. It polls
+// by hand: require.Eventually runs its condition on a goroutine of its own.
+func requireGoroutines(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; runtime.NumGoroutine() > n; i++ {
+		if i == 1000 {
+			t.Fatalf("got %d goroutines, wanted %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var flushed []string
+	cache := tinylfu.NewSync(100, 10000,
+		tinylfu.WithJanitor(time.Millisecond),
+		tinylfu.WithWriteCoalescing(time.Hour, func(item *tinylfu.Item) {
+			flushed = append(flushed, item.Key)
+		}))
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a"})
+
+	require.NoError(t, cache.Close())
+	require.Equal(t, []string{"a"}, flushed)
+	require.NotPanics(t, func() { require.NoError(t, cache.Close()) })
+	require.Equal(t, []string{"a"}, flushed)
+
+	requireGoroutines(t, before)
+
+	val, ok := cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "a", val)
+
+	var closer io.Closer = tinylfu.NewSharded(400, 4000, 4, tinylfu.WithJanitor(time.Millisecond))
+	require.NoError(t, closer.Close())
+	require.NoError(t, closer.Close())
+	requireGoroutines(t, before)
+}
+
+func TestResize(t *testing.T) {
+	var evicted []string
+	var errs []error
+	cache := tinylfu.New(100, 10000, tinylfu.WithOnEvict(func(item *tinylfu.Item) {
+		evicted = append(evicted, item.Key)
+	}), tinylfu.WithOnError(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	for i := 0; i < 100; i++ {
+		cache.Set(&tinylfu.Item{Key: strconv.Itoa(i), Value: i})
+	}
+	for n := 0; n < 5; n++ {
+		for i := 0; i < 20; i++ {
+			_, ok := cache.Get(strconv.Itoa(i))
+			require.True(t, ok)
+		}
+	}
+
+	require.NoError(t, cache.Resize(1000))
+	for i := 100; i < 500; i++ {
+		cache.Set(&tinylfu.Item{Key: strconv.Itoa(i), Value: i})
+	}
+	require.Equal(t, 500, cache.Len())
+	require.Empty(t, evicted)
+
+	require.NoError(t, cache.Resize(200))
+	require.Equal(t, 200, cache.Len())
+	require.Len(t, evicted, 300)
+	for i := 0; i < 20; i++ {
+		_, ok := cache.Peek(strconv.Itoa(i))
+		require.True(t, ok, i)
+	}
+	require.Empty(t, cache.OrphanedKeys())
+
+	// The smaller capacity holds from now on.
+	for i := 500; i < 1000; i++ {
+		cache.Set(&tinylfu.Item{Key: strconv.Itoa(i), Value: i})
+	}
+	require.Equal(t, 200, cache.Len())
+
+	require.Empty(t, errs)
+
+	// Below 100 the window is clamped to one slot, as New does.
+	require.NoError(t, cache.Resize(10))
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], tinylfu.ErrInvalidSize)
+	require.Equal(t, 10, cache.Len())
+	for i := 1000; i < 1100; i++ {
+		cache.Set(&tinylfu.Item{Key: strconv.Itoa(i), Value: i})
+	}
+	require.Equal(t, 10, cache.Len())
+	require.Empty(t, cache.OrphanedKeys())
+
+	require.ErrorIs(t, cache.Resize(0), tinylfu.ErrInvalidSize)
+	require.Equal(t, 10, cache.Len())
+}
+
+func TestSyncClearConcurrent(t *testing.T) {
+	cache := tinylfu.NewSync(100, 1000)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				key := fmt.Sprintf("%d-%d", g, i%200)
+				if val, ok := cache.Get(key); ok && val != key {
+					t.Errorf("got %v for key %q", val, key)
+					return
+				}
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+		}(g)
+	}
+
+	for i := 0; i < 200; i++ {
+		cache.Clear()
+		require.Empty(t, cache.OrphanedKeys())
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestEntryAges(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+	require.Zero(t, cache.OldestEntryAge())
+	require.Zero(t, cache.NewestEntryAge())
+
+	cache.Set(&tinylfu.Item{Key: "old", Value: "a"})
+	clock.Add(time.Minute)
+	cache.Set(&tinylfu.Item{Key: "mid", Value: "b"})
+	clock.Add(time.Minute)
+	cache.Set(&tinylfu.Item{Key: "new", Value: "c"})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "d", ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(10 * time.Second)
+
+	require.Equal(t, 2*time.Minute+10*time.Second, cache.OldestEntryAge())
+	require.Equal(t, 10*time.Second, cache.NewestEntryAge())
+
+	cache.Del("old")
+	require.Equal(t, time.Minute+10*time.Second, cache.OldestEntryAge())
+}
+
+func TestApply(t *testing.T) {
+	cache := tinylfu.New(100, 10000, tinylfu.WithMaxValueBytes(3))
+	cache.Set(&tinylfu.Item{Key: "old", Value: "x"})
+
+	err := cache.Apply([]tinylfu.Op{
+		{Type: tinylfu.OpSet, Key: "foo", Value: "bar"},
+		{Type: tinylfu.OpDel, Key: "old"},
+		{Type: tinylfu.OpSet, Key: "big", Value: "too large"},
+		{Type: tinylfu.OpSet, Key: "never", Value: "set"},
+	})
+	require.True(t, errors.Is(err, tinylfu.ErrInvalidItem))
+
+	_, ok := cache.Get("foo")
+	require.True(t, ok)
+	_, ok = cache.Get("old")
+	require.False(t, ok)
+	_, ok = cache.Get("never")
+	require.False(t, ok)
+
+	require.Error(t, cache.Apply([]tinylfu.Op{{Type: tinylfu.OpType(42)}}))
+}
+
+func TestApplyCoalesced(t *testing.T) {
+	var flushed []string
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithMaxValueBytes(3),
+		tinylfu.WithWriteCoalescing(time.Hour, func(item *tinylfu.Item) {
+			flushed = append(flushed, item.Key)
+		}))
+
+	err := cache.Apply([]tinylfu.Op{
+		{Type: tinylfu.OpSet, Key: "foo", Value: "bar"},
+		{Type: tinylfu.OpSet, Key: "big", Value: "too large"},
+		{Type: tinylfu.OpSet, Key: "never", Value: "set"},
+	})
+	require.True(t, errors.Is(err, tinylfu.ErrInvalidItem))
+	require.EqualError(t, err, "op 1: "+tinylfu.ErrInvalidItem.Error())
+
+	cache.Flush()
+	require.Equal(t, []string{"foo"}, flushed)
+	_, ok := cache.Get("foo")
+	require.True(t, ok)
+	_, ok = cache.Get("never")
+	require.False(t, ok)
+}
+
+func TestMSetDuplicates(t *testing.T) {
+	var evicted []string
+	batch := func() []*tinylfu.Item {
+		return []*tinylfu.Item{
+			{Key: "a", Value: 1, OnEvict: func() { evicted = append(evicted, "a1") }},
+			{Key: "b", Value: 2},
+			{Key: "a", Value: 3, OnEvict: func() { evicted = append(evicted, "a3") }},
+		}
+	}
+
+	cache := tinylfu.New(100, 10000)
+	require.NoError(t, cache.MSet(batch()))
+	value, ok := cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 3, value)
+	require.Equal(t, uint64(1), cache.PeekResult("a").Version())
+	cache.Del("a")
+	require.Equal(t, []string{"a3"}, evicted)
+
+	strict := tinylfu.New(100, 10000, tinylfu.WithStrictBatches())
+	err := strict.MSet(batch())
+	require.True(t, errors.Is(err, tinylfu.ErrDuplicateKey))
+	require.EqualError(t, err, `duplicate key in batch: "a"`)
+	require.Zero(t, strict.Len())
+
+	require.NoError(t, strict.MSet(batch()[:2]))
+	require.Equal(t, 2, strict.Len())
+}
+
+func TestSyncApplyAtomic(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+	keys := []string{"a", "b", "c"}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				seen := make(map[string]interface{})
+				for k, v := range cache.All() {
+					seen[k] = v
+				}
+				if len(seen) != 0 && len(seen) != len(keys) {
+					t.Errorf("partial batch: %v", seen)
+					return
+				}
+				for _, v := range seen {
+					if v != seen["a"] {
+						t.Errorf("mixed batches: %v", seen)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		ops := make([]tinylfu.Op, len(keys))
+		for j, key := range keys {
+			ops[j] = tinylfu.Op{Type: tinylfu.OpSet, Key: key, Value: i}
+			if i%3 == 0 {
+				ops[j].Type = tinylfu.OpDel
+			}
+		}
+		require.NoError(t, cache.Apply(ops))
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestMerge(t *testing.T) {
+	now := time.Now()
+
+	build := func() (*tinylfu.T, *tinylfu.T) {
+		dst := tinylfu.New(100, 10000)
+		dst.Set(&tinylfu.Item{Key: "dst-only", Value: "dst"})
+		dst.Set(&tinylfu.Item{Key: "newer-dst", Value: "dst", SourceTime: now.Add(time.Second)})
+		dst.Set(&tinylfu.Item{Key: "newer-src", Value: "dst", SourceTime: now})
+
+		src := tinylfu.New(100, 10000)
+		src.Set(&tinylfu.Item{Key: "src-only", Value: "src"})
+		src.Set(&tinylfu.Item{Key: "newer-dst", Value: "src", SourceTime: now})
+		src.Set(&tinylfu.Item{Key: "newer-src", Value: "src", SourceTime: now.Add(time.Second)})
+		src.Set(&tinylfu.Item{Key: "expired", Value: "src", ExpireAt: now.Add(-time.Second)})
+
+		return dst, src
+	}
+
+	tests := []struct {
+		policy    tinylfu.MergePolicy
+		newerDst  string
+		newerSrc  string
+		carryFreq bool
+	}{
+		{tinylfu.MergeKeepExisting, "dst", "dst", false},
+		{tinylfu.MergePreferIncoming, "src", "src", false},
+		{tinylfu.MergePreferNewer, "dst", "src", true},
+	}
+
+	for _, test := range tests {
+		dst, src := build()
+		dst.Merge(src, test.policy, test.carryFreq)
+
+		for key, want := range map[string]string{
+			"dst-only":  "dst",
+			"src-only":  "src",
+			"newer-dst": test.newerDst,
+			"newer-src": test.newerSrc,
+		} {
+			got, ok := dst.Get(key)
+			require.True(t, ok, "policy %d key %s", test.policy, key)
+			require.Equal(t, want, got, "policy %d key %s", test.policy, key)
+		}
+
+		_, ok := dst.Get("expired")
+		require.False(t, ok)
+	}
+}
+
+func TestMergeReplacedCallbacks(t *testing.T) {
+	var fired []string
+	callback := func(owner string) func(string, interface{}, tinylfu.EvictionReason) {
+		return func(key string, value interface{}, reason tinylfu.EvictionReason) {
+			fired = append(fired, owner+" "+reason.String())
+		}
+	}
+
+	clock := newManualClock()
+	dst := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithMaxBytes(100))
+	dst.Set(&tinylfu.Item{Key: "k", Value: make([]byte, 50), OnEvictReason: callback("dst")})
+
+	src := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	src.Set(&tinylfu.Item{
+		Key:           "k",
+		Value:         make([]byte, 50),
+		OnEvictReason: callback("src"),
+		ProtectUntil:  clock.Now().Add(time.Minute),
+	})
+
+	dst.Merge(src, tinylfu.MergePreferIncoming, false)
+	require.Equal(t, []string{"dst replaced"}, fired)
+
+	// "k" is the least valuable entry but the protection came along.
+	dst.Set(&tinylfu.Item{Key: "a", Value: make([]byte, 50)})
+	dst.Set(&tinylfu.Item{Key: "b", Value: make([]byte, 50)})
+	require.True(t, dst.PeekResult("k").Found())
+
+	dst.Del("k")
+	require.Equal(t, []string{"dst replaced", "src deleted"}, fired)
+}
+
+func TestMergePromotedEntry(t *testing.T) {
+	dst := tinylfu.New(100, 10000)
+	for i := 0; i < 100; i++ {
+		dst.Set(&tinylfu.Item{Key: fmt.Sprint("p", i), Value: i})
+	}
+	for i := 0; i < 100; i++ {
+		dst.Get(fmt.Sprint("p", i))
+	}
+
+	// "k" loses admission once to the doorkeeper and wins the second time.
+	for _, filler := range []string{"f1", "f2"} {
+		dst.Set(&tinylfu.Item{Key: "k", Value: "dst"})
+		for i := 0; i < 5; i++ {
+			dst.Get("k")
+		}
+		dst.Set(&tinylfu.Item{Key: filler, Value: filler})
+	}
+	segment, ok := dst.SegmentOf("k")
+	require.True(t, ok)
+	require.Equal(t, tinylfu.SegmentProbation, segment)
+	var protected int
+	dst.RangeProtected(func(string, interface{}) bool {
+		protected++
+		return true
+	})
+	require.Equal(t, 80, protected)
+
+	// Replacing "k" promotes it, swapping it with the protected tail.
+	now := time.Now()
+	var fired bool
+	src := tinylfu.New(100, 10000)
+	src.Set(&tinylfu.Item{
+		Key:          "k",
+		Value:        "src",
+		SourceTime:   now,
+		ProtectUntil: now.Add(time.Hour),
+		OnEvict:      func() { fired = true },
+	})
+	dst.Merge(src, tinylfu.MergePreferIncoming, false)
+
+	segment, _ = dst.SegmentOf("k")
+	require.Equal(t, tinylfu.SegmentProtected, segment)
+
+	var buf bytes.Buffer
+	require.NoError(t, dst.Snapshot(&buf))
+	dec := gob.NewDecoder(&buf)
+	var found bool
+	for {
+		var e tinylfu.SavedEntry
+		if dec.Decode(&e) != nil {
+			break
+		}
+		if e.Key == "k" {
+			found = true
+			require.Equal(t, "src", e.Value)
+			require.True(t, e.SourceTime.Equal(now))
+			require.True(t, e.ProtectUntil.Equal(now.Add(time.Hour)))
+		}
+	}
+	require.True(t, found)
+
+	dst.Del("k")
+	require.True(t, fired)
+}
+
+func TestMergeCallbackModifiesOther(t *testing.T) {
+	src := tinylfu.New(100, 10000)
+	dst := tinylfu.New(100, 10000)
+
+	// Replacing "k" in dst churns src while Merge walks it.
+	dst.Set(&tinylfu.Item{Key: "k", Value: "dst", OnEvictReason: func(string, interface{}, tinylfu.EvictionReason) {
+		for i := 0; i < 15; i++ {
+			src.Del(strconv.Itoa(i))
+			src.Set(&tinylfu.Item{Key: "new" + strconv.Itoa(i), Value: i})
+		}
+	}})
+
+	src.Set(&tinylfu.Item{Key: "k", Value: "src"})
+	for i := 0; i < 15; i++ {
+		src.Set(&tinylfu.Item{Key: strconv.Itoa(i), Value: i})
+	}
+
+	dst.Merge(src, tinylfu.MergePreferIncoming, false)
+	require.Equal(t, 16, dst.Len())
+	for i := 0; i < 15; i++ {
+		require.True(t, dst.PeekResult(strconv.Itoa(i)).Found(), i)
+	}
+	require.Empty(t, dst.OrphanedKeys())
+}
+
+func TestDistinctKeysSeen(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		cache := tinylfu.New(100, 1e6, tinylfu.WithKeyEstimates())
+
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			cache.Get(key)
+			cache.Get(key)
+		}
+
+		got := float64(cache.DistinctKeysSeen())
+		require.InEpsilon(t, float64(n), got, 0.05, "n=%d", n)
+	}
+
+	cache := tinylfu.New(100, 10, tinylfu.WithKeyEstimates())
+	for i := 0; i < 10; i++ {
+		cache.Get(fmt.Sprintf("key-%d", i))
+	}
+	// The Get that triggered the reset counts towards the new epoch.
+	require.Equal(t, uint64(1), cache.DistinctKeysSeen())
+
+	// The estimates are off by default.
+	cache = tinylfu.New(100, 1e6)
+	cache.Get("key")
+	cache.Get("key")
+	require.Zero(t, cache.DistinctKeysSeen())
+	require.Zero(t, cache.WorkingSetEstimate())
+}
+
+func TestEpoch(t *testing.T) {
+	for _, opts := range [][]tinylfu.Option{nil, {tinylfu.WithIncrementalReset(4)}} {
+		cache := tinylfu.New(100, 10, opts...)
+		require.Zero(t, cache.Epoch())
+
+		for i := 1; i <= 35; i++ {
+			cache.Get("foo")
+			require.Equal(t, uint64(i/10), cache.Epoch(), "get %d", i)
+		}
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	var loads int
+	load := func() (interface{}, time.Time, error) {
+		loads++
+		return fmt.Sprintf("value-%d", loads), clock.Now().Add(time.Minute), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		value, err := cache.GetOrSet("foo", load)
+		require.NoError(t, err)
+		require.Equal(t, "value-1", value)
+	}
+	require.Equal(t, 1, loads)
+
+	clock.Add(2 * time.Minute)
+	value, err := cache.GetOrSet("foo", load)
+	require.NoError(t, err)
+	require.Equal(t, "value-2", value)
+
+	errLoad := errors.New("load failed")
+	_, err = cache.GetOrSet("bar", func() (interface{}, time.Time, error) {
+		return "ignored", time.Time{}, errLoad
+	})
+	require.Equal(t, errLoad, err)
+	_, ok := cache.Get("bar")
+	require.False(t, ok)
+}
+
+func TestGetOrCompute(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+
+	var loads int
+	load := func() (interface{}, time.Time, error) {
+		loads++
+		return "value", time.Time{}, nil
+	}
+
+	value, loaded, err := cache.GetOrCompute("foo", load)
+	require.NoError(t, err)
+	require.True(t, loaded)
+	require.Equal(t, "value", value)
+
+	value, loaded, err = cache.GetOrCompute("foo", load)
+	require.NoError(t, err)
+	require.False(t, loaded)
+	require.Equal(t, "value", value)
+	require.Equal(t, 1, loads)
+
+	errLoad := errors.New("load failed")
+	_, loaded, err = cache.GetOrCompute("bar", func() (interface{}, time.Time, error) {
+		return nil, time.Time{}, errLoad
+	})
+	require.Equal(t, errLoad, err)
+	require.True(t, loaded)
+	_, ok := cache.Get("bar")
+	require.False(t, ok)
+}
+
+func TestDo(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+
+	var loads int32
+	release := make(chan struct{})
+	load := func() (interface{}, time.Time, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "value", time.Time{}, nil
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	values := make([]interface{}, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = cache.Do("foo", load)
+		}(i)
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&loads) > 0 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	for i := 0; i < n; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, "value", values[i])
+	}
+	value, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "value", value)
+
+	errLoad := errors.New("load failed")
+	_, err := cache.Do("bar", func() (interface{}, time.Time, error) {
+		return nil, time.Time{}, errLoad
+	})
+	require.Equal(t, errLoad, err)
+	_, ok = cache.Get("bar")
+	require.False(t, ok)
+
+	value, err = cache.Do("bar", func() (interface{}, time.Time, error) {
+		return "bar", time.Time{}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "bar", value)
+
+	require.Panics(t, func() {
+		_, _ = cache.Do("baz", func() (interface{}, time.Time, error) { panic("boom") })
+	})
+	value, err = cache.Do("baz", func() (interface{}, time.Time, error) {
+		return "baz", time.Time{}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "baz", value)
+}
+
+func TestReserveCommit(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 100000, tinylfu.WithClock(clock))
+
+	r, ok := cache.Reserve("foo")
+	require.True(t, ok)
+	require.Equal(t, "foo", r.Key())
+	require.True(t, cache.Commit(r, "bar", time.Minute))
+
+	value, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", value)
+	clock.Add(2 * time.Minute)
+	_, ok = cache.Get("foo")
+	require.False(t, ok)
+
+	r, ok = cache.Reserve("late")
+	require.True(t, ok)
+	clock.Add(11 * time.Second)
+	require.False(t, cache.Commit(r, "value", 0))
+	_, ok = cache.Get("late")
+	require.False(t, ok)
+	require.False(t, cache.Commit(tinylfu.Reservation{}, "value", 0))
+}
+
+func TestReserveFullCache(t *testing.T) {
+	cache := tinylfu.NewSync(100, 100000)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		for j := 0; j < 3; j++ {
+			cache.Get(key)
+		}
+		cache.Set(&tinylfu.Item{Key: key, Value: i})
+	}
+	require.Equal(t, 100, cache.Len())
+
+	_, ok := cache.Reserve("cold")
+	require.False(t, ok)
+
+	for j := 0; j < 6; j++ {
+		cache.Get("hot")
+	}
+	r, ok := cache.Reserve("hot")
+	require.True(t, ok)
+	require.True(t, cache.Commit(r, "value", 0))
+
+	segment, ok := cache.SegmentOf("hot")
+	require.True(t, ok)
+	require.Equal(t, tinylfu.SegmentProbation, segment)
+	require.Equal(t, 100, cache.Len())
+}
+
+func TestSetWarnsOnOverwrite(t *testing.T) {
+	var warnings []string
+	cache := tinylfu.New(100, 10000, tinylfu.WithSetWarnsOnOverwrite(func(key string, old, new interface{}) {
+		warnings = append(warnings, fmt.Sprintf("%s: %v -> %v", key, old, new))
+	}))
+
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+	require.Empty(t, warnings)
+
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "baz"})
+	require.Equal(t, tinylfu.ErrKeyAlreadyExists, cache.Add(&tinylfu.Item{Key: "foo", Value: "qux"}))
+	require.Equal(t, []string{"foo: bar -> baz"}, warnings)
+}
+
+func TestWorkingSetEstimate(t *testing.T) {
+	cache := tinylfu.New(1000, 1e6, tinylfu.WithKeyEstimates())
+
+	// 5000 keys read twice each, 5000 read once.
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("hot-%d", i)
+		cache.Get(key)
+		cache.Get(key)
+		cache.Get(fmt.Sprintf("cold-%d", i))
+	}
+
+	got := cache.WorkingSetEstimate()
+	require.Greater(t, got, 1000)
+	require.Less(t, uint64(got), cache.DistinctKeysSeen())
+}
+
+func TestTypeEnforcement(t *testing.T) {
+	cache := tinylfu.New(100, 10000, tinylfu.WithTypeEnforcement())
+
+	require.NoError(t, cache.Add(&tinylfu.Item{Key: "foo", Value: "bar"}))
+
+	err := cache.Add(&tinylfu.Item{Key: "int", Value: 42})
+	require.Equal(t, tinylfu.ErrInvalidItem, err)
+
+	cache.Set(&tinylfu.Item{Key: "foo", Value: 42})
+	got, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", got)
+
+	require.NoError(t, cache.Add(&tinylfu.Item{Key: "baz", Value: "qux"}))
+
+	// Only a value that is stored fixes the type.
+	cache = tinylfu.New(100, 10000, tinylfu.WithTypeEnforcement(), tinylfu.WithMaxValueBytes(8))
+	require.Equal(t, tinylfu.ErrInvalidItem, cache.Add(&tinylfu.Item{Key: "big", Value: strings.Repeat("x", 100)}))
+	require.NoError(t, cache.Add(&tinylfu.Item{Key: "int", Value: 42}))
+	require.Equal(t, tinylfu.ErrInvalidItem, cache.Add(&tinylfu.Item{Key: "foo", Value: "bar"}))
+
+	cache = tinylfu.New(100, 10000)
+	require.NoError(t, cache.Add(&tinylfu.Item{Key: "foo", Value: "bar"}))
+	require.NoError(t, cache.Add(&tinylfu.Item{Key: "int", Value: 42}))
+}
+
+func TestOnHitOnMiss(t *testing.T) {
+	clock := newManualClock()
+	var hits, misses []string
+	cache := tinylfu.NewSync(100, 10000,
+		tinylfu.WithClock(clock),
+		tinylfu.WithOnHit(func(key string) { hits = append(hits, key) }),
+		tinylfu.WithOnMiss(func(key string) { misses = append(misses, key) }))
+
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a"})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "b", ExpireAt: clock.Now().Add(time.Minute)})
+	require.Empty(t, hits)
+
+	cache.Get("a")
+	cache.Get("b")
+	cache.Get("c")
+	clock.Add(2 * time.Minute)
+	cache.Get("b")
+	cache.GetResult("a")
+
+	require.Equal(t, []string{"a", "b", "a"}, hits)
+	require.Equal(t, []string{"c", "b"}, misses)
+	stats := cache.Stats()
+	require.Equal(t, uint64(len(hits)), stats.Hits)
+	require.Equal(t, uint64(len(misses)), stats.Misses)
+
+	// A Set of a resident key counts as an access.
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a2"})
+	require.Equal(t, []string{"a", "b", "a", "a"}, hits)
+	require.Equal(t, stats.Hits, cache.Stats().Hits)
+}
+
+func TestAccessSampler(t *testing.T) {
+	const n = 100000
+
+	var hits, misses int
+	cache := tinylfu.New(100, 10000, tinylfu.WithAccessSampler(0.1, func(key string, hit bool) {
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+	}))
+	cache.Set(&tinylfu.Item{Key: "hit", Value: "hit"})
+
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			cache.Get("hit")
+		} else {
+			cache.Get("miss")
+		}
+	}
+
+	require.InEpsilon(t, 0.1*n, hits+misses, 0.1)
+	require.InEpsilon(t, hits, misses, 0.1)
+}
+
+func BenchmarkAccessSampler(b *testing.B) {
+	run := func(b *testing.B, opts ...tinylfu.Option) {
+		cache := tinylfu.New(1000, 10000, opts...)
+		cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cache.Get("foo")
+		}
+	}
+
+	b.Run("off", func(b *testing.B) { run(b) })
+	b.Run("0.01", func(b *testing.B) {
+		run(b, tinylfu.WithAccessSampler(0.01, func(string, bool) {}))
+	})
+}
+
+func TestShardedCache(t *testing.T) {
+	cache := tinylfu.NewSharded(8000, 80000, 8)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d-%d", g, i)
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	require.Equal(t, 800, cache.Len())
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d-%d", g, i)
+			val, ok := cache.Get(key)
+			require.True(t, ok, key)
+			require.Equal(t, key, val)
+		}
+	}
+
+	cache.Del("key-0-0")
+	_, ok := cache.Get("key-0-0")
+	require.False(t, ok)
+	require.Equal(t, tinylfu.ErrKeyAlreadyExists, cache.Add(&tinylfu.Item{Key: "key-1-0"}))
+}
+
+func TestShardedCacheHasher(t *testing.T) {
+	// The high half of the hash picks the shard: 0 puts every key in the
+	// first one.
+	cache := tinylfu.NewSharded(400, 4000, 4, tinylfu.WithHasher(func(key string) uint64 {
+		return uint64(crc32.ChecksumIEEE([]byte(key)))
+	}))
+
+	for i := 0; i < 400; i++ {
+		key := strconv.Itoa(i)
+		cache.Set(&tinylfu.Item{Key: key, Value: i})
+	}
+	require.LessOrEqual(t, cache.Len(), 100)
+
+	cache.Set(&tinylfu.Item{Key: "k", Value: "v"})
+	val, ok := cache.Get("k")
+	require.True(t, ok)
+	require.Equal(t, "v", val)
+}
+
+func BenchmarkShardedSet(b *testing.B) {
+	const size = 1e5
+
+	keys := make([]string, size)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	// Run with -cpu 1,4,8: sharding only pays off once Sets run in parallel.
+	run := func(b *testing.B, cache tinylfu.LFU) {
+		var seed uint64
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := int(atomic.AddUint64(&seed, 7919))
+			for pb.Next() {
+				key := keys[i%len(keys)]
+				cache.Set(&tinylfu.Item{Key: key, Value: i})
+				i++
+			}
+		})
+	}
+
+	b.Run("sync", func(b *testing.B) { run(b, tinylfu.NewSync(size, size)) })
+	b.Run("sharded", func(b *testing.B) { run(b, tinylfu.NewSharded(size, size, 16)) })
+}
+
+func TestPin(t *testing.T) {
+	run := func(pin bool) *tinylfu.T {
+		cache := tinylfu.New(10, 10000)
+		cache.Set(&tinylfu.Item{Key: "pinned", Value: "pinned"})
+		if pin {
+			require.True(t, cache.Pin("pinned"))
+		}
+
+		for round := 0; round < 10; round++ {
+			for i := 0; i < 20; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				for j := 0; j < 3; j++ {
+					cache.Get(key)
+				}
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+		}
+
+		return cache
+	}
+
+	cache := run(false)
+	_, ok := cache.Get("pinned")
+	require.False(t, ok)
+
+	cache = run(true)
+	_, ok = cache.Get("pinned")
+	require.True(t, ok)
+	require.Equal(t, 1, cache.Stats().Pinned)
+	require.LessOrEqual(t, cache.Stats().Size, cache.Stats().Capacity)
+
+	require.False(t, cache.Pin("missing"))
+	require.True(t, cache.Unpin("pinned"))
+	require.Zero(t, cache.Stats().Pinned)
+
+	require.True(t, cache.Pin("pinned"))
+	cache.Del("pinned")
+	require.Zero(t, cache.Stats().Pinned)
+}
+
+func TestGetAndDelete(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+
+	var evicted int32
+	cache.Set(&tinylfu.Item{
+		Key:     "job",
+		Value:   "payload",
+		OnEvict: func() { atomic.AddInt32(&evicted, 1) },
+	})
+
+	var wg sync.WaitGroup
+	var got int32
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if val, ok := cache.GetAndDelete("job"); ok {
+				require.Equal(t, "payload", val)
+				atomic.AddInt32(&got, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), got)
+	require.Equal(t, int32(1), evicted)
+
+	_, ok := cache.GetAndDelete("job")
+	require.False(t, ok)
+}
+
+func TestNewChecked(t *testing.T) {
+	_, err := tinylfu.NewChecked(50, 10000)
+	require.True(t, errors.Is(err, tinylfu.ErrInvalidSize))
+	require.EqualError(t, err,
+		"invalid size: size 50 leaves the window segment empty, the minimum size is 100")
+
+	cache, err := tinylfu.NewChecked(100, 10000)
+	require.NoError(t, err)
+	require.Equal(t, 100, cache.Stats().Capacity)
+
+	var errs []error
+	cache = tinylfu.New(50, 10000, tinylfu.WithOnError(func(err error) {
+		errs = append(errs, err)
+	}))
+	require.NotNil(t, cache)
+	require.Len(t, errs, 1)
+	require.True(t, errors.Is(errs[0], tinylfu.ErrInvalidSize))
+}
+
+func TestNewCheckedOptions(t *testing.T) {
+	for _, size := range []int{-1, 0, 1, 2, 9} {
+		_, err := tinylfu.NewChecked(size, 10000, tinylfu.WithWindowPercent(10))
+		require.True(t, errors.Is(err, tinylfu.ErrInvalidSize), "size %d", size)
+		require.Contains(t, err.Error(), "the minimum size is 10")
+	}
+
+	cache, err := tinylfu.NewChecked(10, 10000, tinylfu.WithWindowPercent(10))
+	require.NoError(t, err)
+	require.Equal(t, 10, cache.Stats().Capacity)
+
+	_, err = tinylfu.NewChecked(100, 10000, tinylfu.WithWindowPercent(200))
+	require.True(t, errors.Is(err, tinylfu.ErrInvalidOption))
+
+	require.Panics(t, func() { tinylfu.New(0, 10000) })
+}
+
+func TestSegmentSizesSumToSize(t *testing.T) {
+	for size := 100; size <= 5000; size++ {
+		cache, err := tinylfu.NewChecked(size, 10000)
+		require.NoError(t, err)
+		require.Equal(t, size, cache.Stats().Capacity, "size %d", size)
+	}
+}
+
+func TestEvictionCounters(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+
+	var callbacks uint64
+	set := func(from, to int) {
+		for i := from; i < to; i++ {
+			cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i, OnEvict: func() { callbacks++ }})
+		}
+	}
+
+	set(0, 300)
+	cache.Del("299")
+	stats := cache.Stats()
+	require.Equal(t, uint64(200), stats.TotalEvictions)
+	require.Equal(t, uint64(200), stats.EvictionsSinceReset)
+	require.Equal(t, uint64(201), callbacks)
+
+	cache.ResetStats()
+	stats = cache.Stats()
+	require.Equal(t, uint64(200), stats.TotalEvictions)
+	require.Zero(t, stats.EvictionsSinceReset)
+
+	set(300, 351)
+	stats = cache.Stats()
+	require.Equal(t, uint64(250), stats.TotalEvictions)
+	require.Equal(t, uint64(50), stats.EvictionsSinceReset)
+}
+
+func TestRangeProtected(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		cache.Set(&tinylfu.Item{Key: key, Value: key})
+	}
+	// Push "d" out of the window too.
+	cache.Set(&tinylfu.Item{Key: "filler", Value: "filler"})
+
+	// A hit in the probation segment promotes to the protected one.
+	cache.Get("a")
+	cache.Get("c")
+
+	var keys []string
+	cache.RangeProtected(func(key string, value interface{}) bool {
+		require.Equal(t, key, value)
+		keys = append(keys, key)
+		return true
+	})
+	require.Equal(t, []string{"c", "a"}, keys)
+
+	keys = nil
+	cache.RangeProtected(func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		return false
+	})
+	require.Equal(t, []string{"c"}, keys)
+}
+
+type userKey struct {
+	tenant string
+	id     int
+}
+
+func (k userKey) CacheKey() string {
+	return fmt.Sprintf("%q:%d", k.tenant, k.id)
+}
+
+func TestAll(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	for i := 0; i < 10; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	cache.Set(&tinylfu.Item{Key: "expired", Value: -1, ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(2 * time.Second)
+
+	got := make(map[string]interface{})
+	for k, v := range cache.All() {
+		got[k] = v
+	}
+	require.Len(t, got, 10)
+	require.Equal(t, 3, got["3"])
+	require.NotContains(t, got, "expired")
+
+	var n int
+	for range cache.All() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	require.Equal(t, 3, n)
+
+	// The write lock can only be taken if the iteration released the read lock.
+	done := make(chan struct{})
+	go func() {
+		cache.Set(&tinylfu.Item{Key: "after", Value: "break"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("All did not release the lock")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	type point struct{ X, Y int }
+
+	clock := newManualClock()
+	items := []tinylfu.Item{
+		{Key: "string", Value: "foo"},
+		{Key: "bytes", Value: []byte("bar")},
+		{Key: "int", Value: 42},
+		{Key: "struct", Value: point{1, 2}},
+		{Key: "duration", Value: time.Second},
+	}
+
+	a := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	b := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	for i := range items {
+		itemA, itemB := items[i], items[len(items)-1-i]
+		a.Set(&itemA)
+		b.Set(&itemB)
+	}
+	b.Get("int")
+	require.Equal(t, a.Fingerprint(), b.Fingerprint())
+
+	b.Set(&tinylfu.Item{Key: "expired", Value: "baz", ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(2 * time.Second)
+	require.Equal(t, a.Fingerprint(), b.Fingerprint())
+
+	b.Set(&tinylfu.Item{Key: "struct", Value: point{2, 1}})
+	require.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+}
+
+func TestMarshalBinary(t *testing.T) {
+	clock := newManualClock()
+	src := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	for i := 0; i < 20; i++ {
+		src.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	src.Set(&tinylfu.Item{Key: "ttl", Value: "live", ExpireAt: clock.Now().Add(time.Hour)})
+	src.Set(&tinylfu.Item{Key: "expired", Value: "dead", ExpireAt: clock.Now().Add(time.Second)})
+	for i := 0; i < 3; i++ {
+		src.Get("7")
+	}
+	clock.Add(2 * time.Second)
+
+	data, err := src.MarshalBinary()
+	require.NoError(t, err)
+
+	dst := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	require.NoError(t, dst.UnmarshalBinary(data))
+	require.Equal(t, src.Fingerprint(), dst.Fingerprint())
+	require.Equal(t, 21, dst.Stats().Size)
+
+	_, ok := dst.Get("expired")
+	require.False(t, ok)
+	require.GreaterOrEqual(t, dst.PeekResult("7").Frequency(), uint8(3))
+
+	// Entries that expire after marshaling are skipped on unmarshal.
+	clock.Add(time.Hour)
+	dst = tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	require.NoError(t, dst.UnmarshalBinary(data))
+	_, ok = dst.Get("ttl")
+	require.False(t, ok)
+	require.Equal(t, 20, dst.Stats().Size)
+
+	require.Error(t, dst.UnmarshalBinary([]byte("garbage")))
+}
+
+func TestSaveLoad(t *testing.T) {
+	for _, codec := range []tinylfu.Codec{tinylfu.GobCodec{}, tinylfu.JSONCodec{}} {
+		t.Run(fmt.Sprintf("%T", codec), func(t *testing.T) {
+			clock := newManualClock()
+			src := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithCodec(codec))
+			for i := 0; i < 20; i++ {
+				src.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: fmt.Sprintf("value-%d", i)})
+			}
+			src.Set(&tinylfu.Item{
+				Key:      "ttl",
+				Value:    "live",
+				ExpireAt: clock.Now().Add(time.Hour),
+				Tags:     []string{"tag"},
+			})
+			src.Set(&tinylfu.Item{Key: "expired", Value: "dead", ExpireAt: clock.Now().Add(time.Second)})
+			for i := 0; i < 3; i++ {
+				src.Get("7")
+			}
+			clock.Add(2 * time.Second)
+
+			var buf bytes.Buffer
+			require.NoError(t, src.Save(&buf))
+
+			dst := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithCodec(codec))
+			require.NoError(t, dst.Load(&buf))
+			require.Equal(t, src.Fingerprint(), dst.Fingerprint())
+			require.Equal(t, 21, dst.Len())
+			require.GreaterOrEqual(t, dst.PeekResult("7").Frequency(), uint8(3))
+			require.Equal(t, clock.Now().Add(time.Hour-2*time.Second).Unix(),
+				dst.MGetEntries([]string{"ttl"})["ttl"].ExpireAt.Unix())
+			require.Equal(t, 1, dst.InvalidateTag("tag"))
+
+			require.Error(t, dst.Load(strings.NewReader("garbage")))
+		})
+	}
+
+	// JSON decodes numbers as float64.
+	src := tinylfu.New(100, 10000, tinylfu.WithCodec(tinylfu.JSONCodec{}))
+	src.Set(&tinylfu.Item{Key: "n", Value: 42})
+	var buf bytes.Buffer
+	require.NoError(t, src.Save(&buf))
+	require.Contains(t, buf.String(), `"Key":"n","Value":42`)
+
+	dst := tinylfu.New(100, 10000, tinylfu.WithCodec(tinylfu.JSONCodec{}))
+	require.NoError(t, dst.Load(&buf))
+	val, _ := dst.Get("n")
+	require.Equal(t, float64(42), val)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	clock := newManualClock()
+	src := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	for i := 0; i < 20; i++ {
+		src.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i, OnEvict: func() {}})
+	}
+	src.Set(&tinylfu.Item{Key: "ttl", Value: []byte("live"), ExpireAt: clock.Now().Add(time.Hour)})
+	src.Set(&tinylfu.Item{Key: "expired", Value: "dead", ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(2 * time.Second)
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Snapshot(&buf))
+
+	dst := tinylfu.New(100, 10000, tinylfu.WithClock(clock))
+	require.NoError(t, dst.Restore(&buf))
+	require.Equal(t, src.Fingerprint(), dst.Fingerprint())
+	require.Equal(t, 21, dst.Len())
+	val, ok := dst.Get("7")
+	require.True(t, ok)
+	require.Equal(t, 7, val)
+	val, ok = dst.Get("ttl")
+	require.True(t, ok)
+	require.Equal(t, []byte("live"), val)
+	_, ok = dst.Get("expired")
+	require.False(t, ok)
+
+	type unregistered struct{ N int }
+	src.Set(&tinylfu.Item{Key: "bad", Value: unregistered{1}})
+	err := src.Snapshot(io.Discard)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"bad"`)
+}
+
+func TestRange(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	want := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint(i)
+		cache.Set(&tinylfu.Item{Key: key, Value: i, ExpireAt: clock.Now().Add(time.Hour)})
+		want[key] = i
+	}
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "x", ExpireAt: clock.Now().Add(time.Second)})
+	clock.Add(2 * time.Second)
+
+	got := make(map[string]interface{})
+	cache.Range(func(key string, value interface{}, expireAt time.Time) bool {
+		require.Equal(t, clock.Now().Add(time.Hour-2*time.Second), expireAt)
+		got[key] = value
+		return true
+	})
+	require.Equal(t, want, got)
+
+	var n int
+	cache.Range(func(string, interface{}, time.Time) bool {
+		n++
+		return n < 5
+	})
+	require.Equal(t, 5, n)
+}
+
+func TestRangeReentrancy(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+	for i := 0; i < 10; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+
+	const msg = "tinylfu: cache modified from within Range, RangeProtected or All"
+	for name, modify := range map[string]func(key string){
+		"Set":   func(string) { cache.Set(&tinylfu.Item{Key: "new", Value: 0}) },
+		"Get":   func(key string) { cache.Get(key) },
+		"Del":   func(key string) { cache.Del(key) },
+		"Clear": func(string) { cache.Clear() },
+	} {
+		require.PanicsWithValue(t, msg, func() {
+			cache.Range(func(key string, _ interface{}, _ time.Time) bool {
+				modify(key)
+				return true
+			})
+		}, name)
+		require.PanicsWithValue(t, msg, func() {
+			for key := range cache.All() {
+				modify(key)
+			}
+		}, name)
+	}
+
+	// Reads that don't move entries are fine, and the cache is usable once
+	// the walk is over.
+	var n int
+	cache.Range(func(key string, _ interface{}, _ time.Time) bool {
+		_, ok := cache.Peek(key)
+		require.True(t, ok)
+		require.Len(t, cache.Keys(), 10)
+		n++
+		return true
+	})
+	require.Equal(t, 10, n)
+	cache.Set(&tinylfu.Item{Key: "new", Value: 0})
+	require.Equal(t, 11, cache.Len())
+}
+
+func TestCompareAndSwapVersion(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+	cache.Set(&tinylfu.Item{Key: "config", Value: "v1"})
+
+	version := cache.PeekResult("config").Version()
+	require.Equal(t, uint64(1), version)
+
+	require.True(t, cache.CompareAndSwapVersion("config", version, "v2"))
+	r := cache.PeekResult("config")
+	require.Equal(t, "v2", r.Value())
+	require.Equal(t, uint64(2), r.Version())
+
+	// A stale version fails and leaves the entry alone.
+	require.False(t, cache.CompareAndSwapVersion("config", version, "v3"))
+	r = cache.PeekResult("config")
+	require.Equal(t, "v2", r.Value())
+	require.Equal(t, uint64(2), r.Version())
+
+	require.False(t, cache.CompareAndSwapVersion("missing", 1, "v1"))
+	_, ok := cache.Peek("missing")
+	require.False(t, ok)
+
+	// Concurrent read-modify-write loops don't lose updates.
+	cache.Set(&tinylfu.Item{Key: "n", Value: 0})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				for {
+					r := cache.PeekResult("n")
+					if cache.CompareAndSwapVersion("n", r.Version(), r.Value().(int)+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, _ := cache.Get("n")
+	require.Equal(t, 400, val)
+}
+
+func TestKeys(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	resident := make(map[string]bool)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprint(i)
+		cache.Set(&tinylfu.Item{
+			Key:      key,
+			Value:    i,
+			ExpireAt: clock.Now().Add(time.Duration(1+i%2) * time.Second),
+			OnEvict:  func() { delete(resident, key) },
+		})
+		resident[key] = true
+	}
+	cache.Del("299")
+	// Odd keys outlive even ones.
+	clock.Add(1500 * time.Millisecond)
+
+	var want []string
+	for key := range resident {
+		if n, _ := strconv.Atoi(key); n%2 == 1 {
+			want = append(want, key)
+		}
+	}
+	require.NotEmpty(t, want)
+	require.ElementsMatch(t, want, cache.Keys())
+}
+
+func TestKeyed(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+
+	cache.SetKeyed(userKey{"acme", 1}, "one", 0)
+	cache.SetKeyed(userKey{"acme", 2}, "two", time.Hour)
+
+	got, ok := cache.GetKeyed(userKey{"acme", 1})
+	require.True(t, ok)
+	require.Equal(t, "one", got)
+
+	got, ok = cache.GetKeyed(userKey{"acme", 2})
+	require.True(t, ok)
+	require.Equal(t, "two", got)
+
+	_, ok = cache.GetKeyed(userKey{"other", 1})
+	require.False(t, ok)
+
+	cache.SetKeyed(userKey{"acme", 1}, "uno", 0)
+	got, _ = cache.GetKeyed(userKey{"acme", 1})
+	require.Equal(t, "uno", got)
+}
+
+func TestWriteCoalescingKeyedOps(t *testing.T) {
+	newCache := func() *tinylfu.SyncT {
+		cache := tinylfu.NewSync(100, 1000, tinylfu.WithWriteCoalescing(time.Hour, nil))
+		cache.Set(&tinylfu.Item{Key: "a", Value: 1})
+		cache.Set(&tinylfu.Item{Key: "a", Value: 2})
+		return cache
+	}
+
+	cache := newCache()
+	value, ok := cache.GetAndDelete("a")
+	require.True(t, ok)
+	require.Equal(t, 2, value)
+	_, ok = cache.Get("a")
+	require.False(t, ok)
+
+	cache = newCache()
+	r := cache.GetResult("a")
+	require.True(t, r.Found())
+	require.Equal(t, 2, r.Value())
+
+	cache = newCache()
+	value, ok = cache.Peek("a")
+	require.True(t, ok)
+	require.Equal(t, 2, value)
+	require.Equal(t, 2, cache.PeekResult("a").Value())
+
+	cache = newCache()
+	_, ok = cache.SegmentOf("a")
+	require.True(t, ok)
+	require.Equal(t, 2, cache.MGetEntries([]string{"a"})["a"].Value)
+
+	cache = newCache()
+	require.True(t, cache.Touch("a", time.Now().Add(time.Minute)))
+	_, ttl, _ := cache.GetWithTTL("a")
+	require.Greater(t, ttl, time.Duration(0))
+
+	cache = newCache()
+	require.True(t, cache.CompareAndSwapVersion("a", 1, 3))
+	value, _ = cache.Get("a")
+	require.Equal(t, 3, value)
+
+	cache = newCache()
+	require.Equal(t, []string{"a"}, cache.Keys())
+	for key, value := range cache.All() {
+		require.Equal(t, "a", key)
+		require.Equal(t, 2, value)
+	}
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	clock := newManualClock()
+
+	var writes []interface{}
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithClock(clock),
+		tinylfu.WithWriteCoalescing(time.Second, func(item *tinylfu.Item) {
+			writes = append(writes, item.Value)
+		}),
+	)
+
+	for i := 0; i < 100; i++ {
+		cache.Set(&tinylfu.Item{Key: "counter", Value: i})
+		clock.Add(time.Millisecond)
+	}
+	require.Empty(t, writes)
+
+	got, ok := cache.Get("counter")
+	require.True(t, ok)
+	require.Equal(t, 99, got)
+	require.Equal(t, []interface{}{99}, writes)
+
+	for i := 100; i < 200; i++ {
+		cache.Set(&tinylfu.Item{Key: "counter", Value: i})
+	}
+	clock.Add(2 * time.Second)
+	cache.Set(&tinylfu.Item{Key: "other", Value: "other"})
+	require.Equal(t, []interface{}{99, 199}, writes)
+
+	cache.Set(&tinylfu.Item{Key: "deleted", Value: "deleted"})
+	cache.Del("deleted")
+
+	require.NoError(t, cache.Close())
+	require.Equal(t, []interface{}{99, 199, "other"}, writes)
+
+	got, ok = cache.Get("counter")
+	require.True(t, ok)
+	require.Equal(t, 199, got)
+}
+
+func TestDoorkeeperDensity(t *testing.T) {
+	cache := tinylfu.New(10, 10000)
+	require.Zero(t, cache.DoorkeeperDensity())
+
+	// Items leaving the window of a full cache go through the doorkeeper.
+	var last float64
+	for i := 0; i < 50000; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprintf("key-%d", i), Value: i})
+		if i%10000 == 9999 {
+			d := cache.DoorkeeperDensity()
+			require.True(t, d > last, "density %f after %d keys", d, i+1)
+			last = d
+		}
+	}
+	require.True(t, last > 0.9, "density %f", last)
+
+	for i := 0; i < 10000; i++ {
+		cache.Get("key")
+	}
+	require.Zero(t, cache.DoorkeeperDensity())
+}
+
+func TestMinEntries(t *testing.T) {
+	fill := func(opts ...tinylfu.Option) *tinylfu.T {
+		cache := tinylfu.New(100, 10000, opts...)
+		for i := 0; i < 10; i++ {
+			cache.Set(&tinylfu.Item{Key: fmt.Sprintf("key-%d", i), Value: make([]byte, 90)})
+		}
+		require.Equal(t, int64(900), cache.Stats().Bytes)
+		return cache
+	}
+
+	cache := fill(tinylfu.WithMaxBytes(1000))
+	cache.Set(&tinylfu.Item{Key: "huge", Value: make([]byte, 800)})
+	_, ok := cache.Get("huge")
+	require.True(t, ok)
+	require.Equal(t, 3, cache.Stats().Size)
+
+	cache = fill(tinylfu.WithMaxBytes(1000), tinylfu.WithMinEntries(5))
+
+	var rejected bool
+	cache.Set(&tinylfu.Item{
+		Key:     "huge",
+		Value:   make([]byte, 800),
+		OnEvict: func() { rejected = true },
+	})
+	require.True(t, rejected)
+	_, ok = cache.Get("huge")
+	require.False(t, ok)
+	require.Equal(t, 10, cache.Stats().Size)
+	require.Equal(t, int64(900), cache.Stats().Bytes)
+
+	cache.Set(&tinylfu.Item{Key: "big", Value: make([]byte, 300)})
+	_, ok = cache.Get("big")
+	require.True(t, ok)
+	require.Equal(t, 8, cache.Stats().Size)
+	require.True(t, cache.Stats().Bytes <= 1000)
+}
+
+// zipfTrace returns a skewed trace of n accesses over keys distinct keys.
+func zipfTrace(n int, keys uint64) []string {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, keys-1)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key-%d", zipf.Uint64())
+	}
+	return trace
+}
+
+func TestSlabLists(t *testing.T) {
+	trace := zipfTrace(100000, 10000)
+	run := func(opts ...tinylfu.Option) *tinylfu.T {
+		opts = append(opts, tinylfu.WithClock(newManualClock()))
+		cache := tinylfu.New(1000, 10000, opts...)
+		for _, key := range trace {
+			if _, ok := cache.Get(key); !ok {
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+		}
+		return cache
+	}
+
+	// The slab lists change the memory layout, not the cache decisions.
+	want, got := run(), run(tinylfu.WithSlabLists())
+	require.Equal(t, want.Stats(), got.Stats())
+	require.ElementsMatch(t, want.Keys(), got.Keys())
+	require.Empty(t, got.OrphanedKeys())
+}
+
+// BenchmarkSlabLists runs the same Get and Set on miss workload with the
+// default lists and with WithSlabLists.
+func BenchmarkSlabLists(b *testing.B) {
+	trace := zipfTrace(100000, 100000)
+	for _, test := range []struct {
+		name string
+		opts []tinylfu.Option
+	}{
+		{"default", nil},
+		{"slab", []tinylfu.Option{tinylfu.WithSlabLists()}},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			cache := tinylfu.New(10000, 100000, test.opts...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := trace[i%len(trace)]
+				if _, ok := cache.Get(key); !ok {
+					cache.Set(&tinylfu.Item{Key: key, Value: i})
+				}
+			}
+		})
+	}
+}
+
+func TestCompareConfigs(t *testing.T) {
+	trace := zipfTrace(100000, 10000)
+
+	small := tinylfu.Config{Size: 100, Samples: 1000}
+	large := tinylfu.Config{Size: 1000, Samples: 10000}
+
+	statsA, statsB := tinylfu.CompareConfigs(trace, small, large)
+	require.Equal(t, uint64(len(trace)), statsA.Hits+statsA.Misses)
+	require.Equal(t, uint64(len(trace)), statsB.Hits+statsB.Misses)
+	require.True(t, statsB.HitRatio() > statsA.HitRatio(),
+		"small %f large %f", statsA.HitRatio(), statsB.HitRatio())
+
+	again, _ := tinylfu.CompareConfigs(trace, small, large)
+	require.Equal(t, statsA, again)
+}
+
+func TestAdmissionBypass(t *testing.T) {
+	// A working set of 60 keys drifting by 40 keys every 2000 reads.
+	r := rand.New(rand.NewSource(1))
+	var trace []string
+	for phase := 0; phase < 50; phase++ {
+		for i := 0; i < 2000; i++ {
+			trace = append(trace, fmt.Sprint(phase*40+r.Intn(60)))
+		}
+	}
+
+	bypass := []tinylfu.Option{tinylfu.WithAdmissionBypass(500)}
+	for _, size := range []int{20, 50, 100} {
+		tinyLFU, slru := tinylfu.CompareConfigs(trace,
+			tinylfu.Config{Size: size, Samples: 1000},
+			tinylfu.Config{Size: size, Samples: 1000, Options: bypass})
+		require.GreaterOrEqual(t, slru.HitRatio(), tinyLFU.HitRatio(), "size %d", size)
+	}
+
+	// Caches at or above the threshold keep admission.
+	a, b := tinylfu.CompareConfigs(trace,
+		tinylfu.Config{Size: 500, Samples: 5000},
+		tinylfu.Config{Size: 500, Samples: 5000, Options: bypass})
+	require.Equal(t, a, b)
+}
+
+func TestAdmissionPolicy(t *testing.T) {
+	hotHitRatio := func(opts ...tinylfu.Option) float64 {
+		cache := tinylfu.New(100, 1000, opts...)
+		get := func(key string) bool {
+			_, ok := cache.Get(key)
+			if !ok {
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+			return ok
+		}
+
+		// 90 hot keys read between scans. Scan keys are read a second time
+		// shortly after, which promotes them in a plain segmented LRU.
+		r := rand.New(rand.NewSource(1))
+		var hits, scan int
+		for i := 0; i < 20000; i++ {
+			if get(fmt.Sprint("hot-", r.Intn(90))) {
+				hits++
+			}
+			for j := 0; j < 10; j++ {
+				get(fmt.Sprint("scan-", scan))
+				get(fmt.Sprint("scan-", scan-20))
+				scan++
+			}
+		}
+		return float64(hits) / 20000
+	}
+
+	tinyLFU := hotHitRatio()
+	always := hotHitRatio(tinylfu.WithAdmissionPolicy(tinylfu.AdmitAlways))
+	require.True(t, tinyLFU > always+0.1, "tinylfu %f always %f", tinyLFU, always)
+
+	// AdmitAlways behaves like the bypass at any size.
+	trace := zipfTrace(20000, 5000)
+	a, b := tinylfu.CompareConfigs(trace,
+		tinylfu.Config{Size: 100, Samples: 1000, Options: []tinylfu.Option{tinylfu.WithAdmissionBypass(1000)}},
+		tinylfu.Config{Size: 100, Samples: 1000, Options: []tinylfu.Option{tinylfu.WithAdmissionPolicy(tinylfu.AdmitAlways)}})
+	require.Equal(t, a, b)
+
+	a, b = tinylfu.CompareConfigs(trace,
+		tinylfu.Config{Size: 100, Samples: 1000},
+		tinylfu.Config{Size: 100, Samples: 1000, Options: []tinylfu.Option{tinylfu.WithAdmissionPolicy(tinylfu.AdmitTinyLFU)}})
+	require.Equal(t, a, b)
+}
+
+type rejectPolicy struct {
+	calls int
+}
+
+func (p *rejectPolicy) Admit(candidateKeyh, victimKeyh uint64, sketch tinylfu.Sketch) bool {
+	p.calls++
+	return false
+}
+
+func TestCustomAdmissionPolicy(t *testing.T) {
+	policy := new(rejectPolicy)
+	cache := tinylfu.New(100, 10000, tinylfu.WithAdmissionPolicy(policy))
+
+	// Entries are admitted without asking the policy until the main segments
+	// are full.
+	for i := 0; i < 100; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint("old-", i), Value: i})
+	}
+	require.Equal(t, 0, policy.calls)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("new-", i)
+		cache.Set(&tinylfu.Item{Key: key, Value: i})
+		cache.Get(key)
+		cache.Get(key)
+	}
+	require.Equal(t, 1000, policy.calls)
+	require.Equal(t, 100, cache.Len())
+
+	// Only the last new key is resident, in the window.
+	for i := 0; i < 999; i++ {
+		_, ok := cache.Peek(fmt.Sprint("new-", i))
+		require.False(t, ok, i)
+	}
+	seg, ok := cache.SegmentOf("new-999")
+	require.True(t, ok)
+	require.Equal(t, tinylfu.SegmentWindow, seg)
+	require.Equal(t, uint64(1000), cache.Stats().Rejections)
+}
+
+func TestSizeForHitRatio(t *testing.T) {
+	trace := zipfTrace(20000, 5000)
+
+	size, achieved := tinylfu.SizeForHitRatio(trace, 0.5, 10000)
+	require.GreaterOrEqual(t, achieved, 0.5)
+	require.Equal(t, achieved, tinylfu.Simulate(trace, tinylfu.Config{Size: size, Samples: 10000}).HitRatio())
+	require.Less(t, tinylfu.Simulate(trace, tinylfu.Config{Size: size / 2, Samples: 10000}).HitRatio(), 0.5)
+
+	size, achieved = tinylfu.SizeForHitRatio(trace, 0.99, 10000)
+	require.Less(t, achieved, 0.99)
+	require.Greater(t, size, 1000)
+}
+
+func TestSizeAtEviction(t *testing.T) {
+	evicted := make(map[string]int64)
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithMaxBytes(100),
+		tinylfu.WithSizer(func(value interface{}) int64 {
+			return int64(value.(int))
+		}),
+		tinylfu.WithOnEvict(func(item *tinylfu.Item) {
+			evicted[item.Key] = item.Size()
+		}),
+	)
+
+	cache.Set(&tinylfu.Item{Key: "string", Value: strings.Repeat("x", 30)})
+	cache.Set(&tinylfu.Item{Key: "bytes", Value: make([]byte, 20)})
+	cache.Set(&tinylfu.Item{Key: "sized", Value: 40})
+	cache.Set(&tinylfu.Item{Key: "resized", Value: 1})
+	cache.Set(&tinylfu.Item{Key: "resized", Value: 10})
+	require.Equal(t, int64(100), cache.Stats().Bytes)
+	require.Empty(t, evicted)
+
+	cache.Set(&tinylfu.Item{Key: "big", Value: 100})
+	require.Equal(t, map[string]int64{"string": 30, "bytes": 20, "sized": 40, "resized": 10}, evicted)
+	require.Equal(t, int64(100), cache.Stats().Bytes)
+
+	cache.Del("big")
+	require.Equal(t, int64(100), evicted["big"])
+}
+
+func TestProtectUntil(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithMaxBytes(100))
+
+	// "protected" is the least valuable entry and would be evicted first.
+	cache.Set(&tinylfu.Item{
+		Key:          "protected",
+		Value:        make([]byte, 50),
+		ProtectUntil: clock.Now().Add(time.Minute),
+	})
+	cache.Set(&tinylfu.Item{Key: "a", Value: make([]byte, 50)})
+	cache.Set(&tinylfu.Item{Key: "b", Value: make([]byte, 50)})
+
+	require.True(t, cache.PeekResult("protected").Found())
+	require.False(t, cache.PeekResult("a").Found())
+	require.True(t, cache.PeekResult("b").Found())
+
+	clock.Add(time.Minute)
+	cache.Set(&tinylfu.Item{Key: "c", Value: make([]byte, 50)})
+
+	require.False(t, cache.PeekResult("protected").Found())
+	require.True(t, cache.PeekResult("b").Found())
+	require.True(t, cache.PeekResult("c").Found())
+}
+
+func TestSegmentEvictions(t *testing.T) {
+	access := func(cache *tinylfu.T, key string) {
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(&tinylfu.Item{Key: key, Value: key})
+		}
+	}
+
+	// A scan of keys seen once: candidates lose admission on leaving the
+	// window.
+	scan := tinylfu.New(100, 1000)
+	for i := 0; i < 5000; i++ {
+		access(scan, fmt.Sprint(i))
+	}
+	stats := scan.Stats()
+	require.Equal(t, stats.TotalEvictions, stats.WindowEvictions+stats.ProbationEvictions)
+	require.Greater(t, stats.WindowEvictions, 10*stats.ProbationEvictions)
+
+	// Bursts of accesses to each key: candidates are hotter than the aged
+	// residents and win admission, evicting from the main segments.
+	bursts := tinylfu.New(100, 200, tinylfu.WithDoorkeeper(&alwaysAllow{}))
+	for i := 0; i < 500; i++ {
+		for j := 0; j < 20; j++ {
+			access(bursts, fmt.Sprint(i))
+		}
+	}
+	stats = bursts.Stats()
+	require.Equal(t, stats.TotalEvictions, stats.WindowEvictions+stats.ProbationEvictions)
+	require.Greater(t, stats.ProbationEvictions, stats.WindowEvictions)
+}
+
+func TestHasher(t *testing.T) {
+	var calls int
+	// FNV-1a, deterministic and independent of xxhash.
+	fnv := func(key string) uint64 {
+		calls++
+		h := uint64(14695981039346656037)
+		for i := 0; i < len(key); i++ {
+			h ^= uint64(key[i])
+			h *= 1099511628211
+		}
+		return h
+	}
+
+	cache := tinylfu.New(100, 10000, tinylfu.WithHasher(fnv))
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprint(i % 50)
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(&tinylfu.Item{Key: key, Value: i})
+		}
+	}
+	require.NotZero(t, calls)
+
+	for i := 0; i < 50; i++ {
+		_, ok := cache.Get(fmt.Sprint(i))
+		require.True(t, ok)
+	}
+	require.Equal(t, uint8(5), cache.PeekResult("7").Frequency())
+	cache.Del("7")
+	_, ok := cache.Get("7")
+	require.False(t, ok)
+}
+
+func TestHashCollisionEstimate(t *testing.T) {
+	fill := func(cache *tinylfu.T) {
+		for i := 0; i < 500; i++ {
+			cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+		}
+	}
+
+	cache := tinylfu.New(1000, 10000)
+	fill(cache)
+	require.Zero(t, cache.HashCollisionEstimate())
+
+	// Hashing by length leaves 3 distinct hashes for the keys 0 to 499.
+	weak := tinylfu.New(1000, 10000, tinylfu.WithHasher(func(key string) uint64 {
+		return uint64(len(key))
+	}))
+	fill(weak)
+	require.Equal(t, weak.Len()-3, weak.HashCollisionEstimate())
+}
+
+func TestFrequency(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+	cache.Set(&tinylfu.Item{Key: "hot", Value: "hot"})
+	cache.Set(&tinylfu.Item{Key: "cold", Value: "cold"})
+
+	for i := 0; i < 10; i++ {
+		cache.Get("hot")
+	}
+	cache.Get("cold")
+	for i := 0; i < 5; i++ {
+		cache.Get("missing")
+	}
+
+	require.Greater(t, cache.Frequency("hot"), cache.Frequency("cold"))
+	require.GreaterOrEqual(t, cache.Frequency("hot"), uint8(10))
+	require.GreaterOrEqual(t, cache.Frequency("missing"), uint8(5))
+	require.Equal(t, cache.Frequency("hot"), cache.Frequency("hot"))
+}
+
+func TestStats(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	var gets int
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprint(i % 150)
+		gets++
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(&tinylfu.Item{Key: key, Value: i, ExpireAt: clock.Now().Add(time.Hour)})
+		}
+	}
+	clock.Add(2 * time.Hour)
+	for i := 0; i < 10; i++ {
+		gets++
+		cache.Get(fmt.Sprint(i))
+	}
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(gets), stats.Hits+stats.Misses)
+	require.NotZero(t, stats.Hits)
+	require.Equal(t, 100, stats.Capacity)
+	require.LessOrEqual(t, stats.Size, stats.Capacity)
+	require.NotZero(t, stats.TotalEvictions)
+	require.NotZero(t, stats.Rejections)
+	require.LessOrEqual(t, stats.Rejections, stats.TotalEvictions)
+	require.NotZero(t, stats.Expirations)
+	require.LessOrEqual(t, stats.Expirations, uint64(10))
+}
+
+func TestSlotReuses(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+	for i := 0; i < 100; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprintf("key-%d", i), Value: i})
+	}
+
+	warm := cache.Stats()
+	require.Equal(t, uint64(100), warm.SlotAllocs)
+
+	for i := 100; i < 1000; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprintf("key-%d", i), Value: i})
+	}
+
+	stats := cache.Stats()
+	require.Equal(t, warm.SlotAllocs, stats.SlotAllocs)
+	require.True(t, stats.SlotReuses >= warm.SlotReuses+900)
+}
+
+func TestEvictionVeto(t *testing.T) {
+	var vetoed []string
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithMaxBytes(100),
+		tinylfu.WithEvictionVeto(func(item *tinylfu.Item) bool {
+			if item.Key == "keep" {
+				vetoed = append(vetoed, item.Key)
+				return true
+			}
+			return false
+		}),
+	)
+
+	cache.Set(&tinylfu.Item{Key: "keep", Value: make([]byte, 50)})
+	cache.Set(&tinylfu.Item{Key: "a", Value: make([]byte, 50)})
+	cache.Set(&tinylfu.Item{Key: "b", Value: make([]byte, 50)})
+
+	require.Equal(t, []string{"keep"}, vetoed)
+	require.True(t, cache.PeekResult("keep").Found())
+	require.False(t, cache.PeekResult("a").Found())
+	require.True(t, cache.PeekResult("b").Found())
+
+	// Vetoing everything can't stall admission.
+	var vetoes int
+	cache = tinylfu.New(100, 10000,
+		tinylfu.WithMaxBytes(100),
+		tinylfu.WithEvictionVeto(func(item *tinylfu.Item) bool {
+			vetoes++
+			return true
+		}),
+	)
+	for i := 0; i < 100; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprintf("key-%d", i), Value: make([]byte, 50)})
+		require.True(t, cache.Stats().Bytes <= 100)
+	}
+	require.True(t, vetoes > 0)
+}
+
+func TestCallbackTiming(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.New(100, 10000,
+		tinylfu.WithClock(clock),
+		tinylfu.WithCallbackTiming(),
+		tinylfu.WithOnExpire(func(item *tinylfu.Item) {
+			clock.Add(10 * time.Millisecond)
+		}),
+	)
+
+	slow := func() { clock.Add(50 * time.Millisecond) }
+	cache.Set(&tinylfu.Item{Key: "evicted", Value: "evicted", OnEvict: slow})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "expired", ExpireAt: clock.Now()})
+	cache.Set(&tinylfu.Item{Key: "silent", Value: "silent"})
+
+	cache.Del("evicted")
+	cache.Del("silent")
+	clock.Add(time.Second)
+	cache.Get("expired")
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(2), stats.EvictCallbacks)
+	require.Equal(t, 60*time.Millisecond, stats.EvictCallbackDuration)
+}
+
+func TestMaxCost(t *testing.T) {
+	cache := tinylfu.New(1000, 10000, tinylfu.WithMaxCost(500))
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, key := range zipfTrace(5000, 300) {
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(&tinylfu.Item{Key: key, Value: key, Cost: 1 + rnd.Int63n(50)})
+		}
+		require.LessOrEqual(t, cache.TotalCost(), int64(500))
+	}
+
+	var sum int64
+	for _, e := range cache.MGetEntries(cache.Keys()) {
+		sum += e.Size
+	}
+	require.Equal(t, sum, cache.TotalCost())
+	require.Greater(t, cache.TotalCost(), int64(400))
+
+	// Admission compares frequency per unit of cost: a cheap key seen once
+	// displaces an expensive key seen twice.
+	run := func(opts ...tinylfu.Option) bool {
+		opts = append(opts, tinylfu.WithDoorkeeper(&alwaysAllow{}))
+		cache := tinylfu.New(100, 10000, opts...)
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprint(i)
+			cache.Get(key)
+			cache.Get(key)
+			cache.Set(&tinylfu.Item{Key: key, Value: key, Cost: 100})
+		}
+		cache.Get("cheap")
+		cache.Set(&tinylfu.Item{Key: "cheap", Value: "cheap", Cost: 1})
+		cache.Set(&tinylfu.Item{Key: "next", Value: "next", Cost: 100})
+		_, ok := cache.Peek("cheap")
+		return ok
+	}
+	require.True(t, run(tinylfu.WithMaxCost(1e9)))
+	require.False(t, run(tinylfu.WithMaxBytes(1e9)))
+}
+
+func TestMaxValueBytes(t *testing.T) {
+	cache := tinylfu.New(100, 10000, tinylfu.WithMaxValueBytes(10))
+
+	require.NoError(t, cache.Add(&tinylfu.Item{Key: "small", Value: "0123456789"}))
+
+	err := cache.Add(&tinylfu.Item{Key: "large", Value: []byte("0123456789a")})
+	require.Equal(t, tinylfu.ErrInvalidItem, err)
+	_, ok := cache.Get("large")
+	require.False(t, ok)
+
+	cache.Set(&tinylfu.Item{Key: "small", Value: "0123456789a"})
+	got, ok := cache.Get("small")
+	require.True(t, ok)
+	require.Equal(t, "0123456789", got)
+}
+
+func TestSegmentOf(t *testing.T) {
+	cache := tinylfu.New(100, 10000)
+
+	_, ok := cache.SegmentOf("key")
+	require.False(t, ok)
+
+	cache.Set(&tinylfu.Item{Key: "key", Value: "value"})
+	segment, ok := cache.SegmentOf("key")
+	require.True(t, ok)
+	require.Equal(t, tinylfu.SegmentWindow, segment)
+
+	cache.Set(&tinylfu.Item{Key: "other", Value: "value"})
+	segment, _ = cache.SegmentOf("key")
+	require.Equal(t, tinylfu.SegmentProbation, segment)
+
+	cache.Get("key")
+	segment, _ = cache.SegmentOf("key")
+	require.Equal(t, tinylfu.SegmentProtected, segment)
+	require.Equal(t, "protected", segment.String())
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	// leaving returns the keys that left the 10 entry window when one key too
+	// many was stored, with the first key read halfway through.
+	leaving := func(opts ...tinylfu.Option) []string {
+		cache := tinylfu.New(1000, 10000, opts...)
+		for i := 0; i <= 10; i++ {
+			key := fmt.Sprint(i)
+			cache.Set(&tinylfu.Item{Key: key, Value: key})
+			if i == 5 {
+				cache.Get("0")
+			}
+		}
+
+		var keys []string
+		for i := 0; i <= 10; i++ {
+			if segment, _ := cache.SegmentOf(fmt.Sprint(i)); segment != tinylfu.SegmentWindow {
+				keys = append(keys, fmt.Sprint(i))
+			}
+		}
+		return keys
+	}
+
+	require.Equal(t, []string{"1"}, leaving())
+	require.Equal(t, []string{"1"}, leaving(tinylfu.WithEvictionPolicy(tinylfu.PolicyLRU)))
+	require.Equal(t, []string{"0"}, leaving(tinylfu.WithEvictionPolicy(tinylfu.PolicyFIFO)))
+}
+
+func TestMGetEntries(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	expireAt := clock.Now().Add(time.Hour)
+	cache.Set(&tinylfu.Item{Key: "a", Value: "foo", ExpireAt: expireAt})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "barbaz"})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "bar"})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "x", ExpireAt: clock.Now().Add(time.Second)})
+	cache.Get("a")
+	clock.Add(2 * time.Second)
+
+	entries := cache.MGetEntries([]string{"a", "b", "missing", "expired"})
+	require.Len(t, entries, 2)
+
+	a := entries["a"]
+	require.Equal(t, "foo", a.Value)
+	require.Equal(t, expireAt, a.ExpireAt)
+	require.Equal(t, tinylfu.SegmentProtected, a.Segment)
+	require.Equal(t, uint8(1), a.Frequency)
+	require.Equal(t, int64(3), a.Size)
+
+	b := entries["b"]
+	require.Equal(t, "bar", b.Value)
+	require.True(t, b.ExpireAt.IsZero())
+	require.Equal(t, tinylfu.SegmentProbation, b.Segment)
+	require.Equal(t, uint64(2), b.Version)
+
+	// MGetEntries is not an access.
+	require.Equal(t, uint8(1), cache.MGetEntries([]string{"a"})["a"].Frequency)
+}
+
+func TestReadOnly(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000)
+	cache.Set(&tinylfu.Item{Key: "foo", Value: "bar"})
+
+	ro := tinylfu.ReadOnly(cache)
+	value, ok := ro.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", value)
+
+	require.Equal(t, tinylfu.ErrReadOnly, ro.Add(&tinylfu.Item{Key: "new", Value: "new"}))
+	ro.Set(&tinylfu.Item{Key: "foo", Value: "changed"})
+	ro.Del("foo")
+
+	value, ok = cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, "bar", value)
+	_, ok = cache.Get("new")
+	require.False(t, ok)
+}
+
+func TestTyped(t *testing.T) {
+	raw := tinylfu.NewSync(100, 10000)
+	users := tinylfu.Typed[userKey](raw)
+
+	users.Set("acme", userKey{"acme", 1}, time.Hour)
+	u, ok := users.Get("acme")
+	require.True(t, ok)
+	require.Equal(t, userKey{"acme", 1}, u)
+	require.Equal(t, tinylfu.ErrKeyAlreadyExists, users.Add("acme", userKey{}, 0))
+
+	// A value of another type stored through the raw cache is a miss.
+	raw.Set(&tinylfu.Item{Key: "wrong", Value: "not a user"})
+	require.NotPanics(t, func() {
+		u, ok = users.Get("wrong")
+	})
+	require.False(t, ok)
+	require.Zero(t, u)
+	_, ok = raw.Get("wrong")
+	require.True(t, ok)
+
+	users.Del("acme")
+	_, ok = users.Get("acme")
+	require.False(t, ok)
+}
+
+func TestGenericCache(t *testing.T) {
+	cache := tinylfu.NewCache[[]byte](100, 10000)
+
+	_, ok := cache.Get("foo")
+	require.False(t, ok)
+
+	cache.Set(&tinylfu.CacheItem[[]byte]{Key: "foo", Value: []byte("bar")})
+	value, ok := cache.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, []byte("bar"), value)
+
+	require.Equal(t, tinylfu.ErrKeyAlreadyExists, cache.Add(&tinylfu.CacheItem[[]byte]{Key: "foo"}))
+	require.NoError(t, cache.Add(&tinylfu.CacheItem[[]byte]{Key: "baz", Value: []byte("qux")}))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		value, ok = cache.Get("foo")
+	})
+	require.Zero(t, allocs)
+	require.Equal(t, []byte("bar"), value)
+
+	// Set still allocates the Item and boxes the slice header, as T.Set
+	// does.
+	bar := []byte("bar")
+	allocs = testing.AllocsPerRun(100, func() {
+		cache.Set(&tinylfu.CacheItem[[]byte]{Key: "foo", Value: bar})
+	})
+	require.Equal(t, float64(2), allocs)
+
+	cache.Del("foo")
+	_, ok = cache.Get("foo")
+	require.False(t, ok)
+
+	errs := tinylfu.NewCache[error](100, 10000)
+	errs.Set(&tinylfu.CacheItem[error]{Key: "nil"})
+	err, ok := errs.Get("nil")
+	require.True(t, ok)
+	require.Nil(t, err)
+}
+
+type fakeTracer struct {
+	events []string
+}
+
+func (t *fakeTracer) AddEvent(name string, attrs map[string]interface{}) {
+	event := fmt.Sprintf("%s %s", name, attrs["key"])
+	if reason, ok := attrs["reason"]; ok {
+		event += fmt.Sprintf(" %s", reason)
+	}
+	t.events = append(t.events, event)
+}
+
+func TestTracer(t *testing.T) {
+	clock := newManualClock()
+	tracer := new(fakeTracer)
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithTracer(tracer))
+
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", ExpireAt: clock.Now().Add(time.Second)})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "b"})
+	cache.Del("b")
+	clock.Add(time.Minute)
+	cache.Get("a")
+
+	require.Equal(t, []string{
+		"tinylfu.admit a",
+		"tinylfu.evict b deleted",
+		"tinylfu.expire a expired",
+	}, tracer.events)
+}
+
+type alwaysAllow struct {
+	resets int
+}
+
+func (d *alwaysAllow) Allow(keyh uint64) bool { return true }
+func (d *alwaysAllow) Reset()                 { d.resets++ }
+
+func TestDoorkeeper(t *testing.T) {
+	admitted := func(opts ...tinylfu.Option) bool {
+		cache := tinylfu.New(100, 10000, opts...)
+		for i := 0; i < 100; i++ {
+			cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+		}
+
+		// A frequent key seen by admission for the first time.
+		for i := 0; i < 3; i++ {
+			cache.Get("new")
+		}
+		cache.Set(&tinylfu.Item{Key: "new", Value: "new"})
+		cache.Set(&tinylfu.Item{Key: "filler", Value: "filler"})
+
+		_, ok := cache.SegmentOf("new")
+		return ok
+	}
+
+	require.False(t, admitted())
+	require.True(t, admitted(tinylfu.WithDoorkeeper(new(alwaysAllow))))
+
+	for _, opts := range [][]tinylfu.Option{nil, {tinylfu.WithIncrementalReset(1)}} {
+		d := new(alwaysAllow)
+		cache := tinylfu.New(100, 10, append(opts, tinylfu.WithDoorkeeper(d))...)
+		for i := 0; i < 30; i++ {
+			cache.Get("foo")
+		}
+		require.Equal(t, 3, d.resets)
+		require.Zero(t, cache.DoorkeeperDensity())
+	}
+}
+
+func TestLastAdmit(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithDoorkeeper(new(alwaysAllow)))
+	for i := 0; i < 100; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	require.True(t, cache.Stats().LastAdmit.IsZero())
+
+	clock.Add(time.Minute)
+	cache.Set(&tinylfu.Item{Key: "cold", Value: "cold"})
+	require.Equal(t, uint64(1), cache.Stats().TotalEvictions)
+	require.True(t, cache.Stats().LastAdmit.IsZero())
+
+	for i := 0; i < 3; i++ {
+		cache.Get("hot")
+	}
+	cache.Set(&tinylfu.Item{Key: "hot", Value: "hot"})
+	clock.Add(time.Minute)
+	admitted := clock.Now()
+	cache.Set(&tinylfu.Item{Key: "filler", Value: "filler"})
+	require.Equal(t, admitted, cache.Stats().LastAdmit)
+
+	clock.Add(time.Minute)
+	cache.Set(&tinylfu.Item{Key: "filler2", Value: "filler2"})
+	require.Equal(t, admitted, cache.Stats().LastAdmit)
+}
+
+func TestResetGrace(t *testing.T) {
+	admissions := func(opts ...tinylfu.Option) int {
+		tracer := new(fakeTracer)
+		opts = append(opts, tinylfu.WithTracer(tracer))
+		cache := tinylfu.New(100, 1000, opts...)
+
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("old-%d", i)
+			cache.Get(key)
+			cache.Set(&tinylfu.Item{Key: key, Value: key})
+		}
+		for cache.Epoch() == 0 {
+			cache.Get("old-0")
+		}
+
+		tracer.events = nil
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("new-%d", i)
+			cache.Get(key)
+			cache.Set(&tinylfu.Item{Key: key, Value: key})
+		}
+
+		var n int
+		for _, event := range tracer.events {
+			if strings.HasPrefix(event, "tinylfu.admit") {
+				n++
+			}
+		}
+		return n
+	}
+
+	without := admissions()
+	with := admissions(tinylfu.WithResetGrace(0.1))
+	t.Logf("admissions after reset: %d without grace, %d with grace", without, with)
+	require.Less(t, without, 5)
+	require.Greater(t, with, 25)
+}
+
+func TestScanGuard(t *testing.T) {
+	hitRatio := func(opts ...tinylfu.Option) float64 {
+		cache := tinylfu.New(1000, 10000, opts...)
+
+		var hits, total int
+		get := func(key string) bool {
+			_, ok := cache.Get(key)
+			if !ok {
+				cache.Set(&tinylfu.Item{Key: key, Value: key})
+			}
+			return ok
+		}
+
+		var scan int
+		for round := 0; round < 10; round++ {
+			for i := 0; i < 5000; i++ {
+				if get(fmt.Sprintf("hot-%d", i%900)) && round > 0 {
+					hits++
+				}
+				if round > 0 {
+					total++
+				}
+
+				if round%2 == 1 {
+					// Scan keys are touched a few times so they get
+					// through the doorkeeper.
+					for j := 0; j < 4; j++ {
+						get(fmt.Sprintf("scan-%d", scan))
+						get(fmt.Sprintf("scan-%d", scan-50))
+						get(fmt.Sprintf("scan-%d", scan-100))
+						scan++
+					}
+				}
+			}
+		}
+
+		return float64(hits) / float64(total)
+	}
+
+	unguarded := hitRatio()
+	guarded := hitRatio(tinylfu.WithScanGuard())
+	require.True(t, guarded > unguarded+0.1, "guarded %f unguarded %f", guarded, unguarded)
+	require.True(t, guarded > 0.95, "guarded %f", guarded)
+}
+
+func TestGetWithTTL(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	cache.Set(&tinylfu.Item{Key: "ttl", Value: "ttl", ExpireAt: clock.Now().Add(time.Minute)})
+	cache.Set(&tinylfu.Item{Key: "forever", Value: "forever"})
+
+	clock.Add(20 * time.Second)
+	value, ttl, ok := cache.GetWithTTL("ttl")
+	require.True(t, ok)
+	require.Equal(t, "ttl", value)
+	require.Equal(t, 40*time.Second, ttl)
+
+	value, ttl, ok = cache.GetWithTTL("forever")
+	require.True(t, ok)
+	require.Equal(t, "forever", value)
+	require.Equal(t, time.Duration(0), ttl)
+
+	clock.Add(time.Minute)
+	_, _, ok = cache.GetWithTTL("ttl")
+	require.False(t, ok)
+	_, _, ok = cache.GetWithTTL("missing")
+	require.False(t, ok)
+	require.Equal(t, uint64(2), cache.Stats().Hits)
+	require.Equal(t, uint64(2), cache.Stats().Misses)
+
+	// WithMaxAge bounds the ttl of entries with a later or no ExpireAt.
+	cache = tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock), tinylfu.WithMaxAge(time.Minute))
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", ExpireAt: clock.Now().Add(time.Hour)})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "b", ExpireAt: clock.Now().Add(time.Second)})
+	cache.Set(&tinylfu.Item{Key: "c", Value: "c"})
+	for key, want := range map[string]time.Duration{"a": time.Minute, "b": time.Second, "c": time.Minute} {
+		_, ttl, ok = cache.GetWithTTL(key)
+		require.True(t, ok)
+		require.Equal(t, want, ttl, key)
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	cache.SetWithTTL("a", "a", time.Minute)
+	cache.SetWithTTL("forever", "forever", 0)
+	require.NoError(t, cache.AddWithTTL("b", "b", 2*time.Minute))
+	require.Equal(t, tinylfu.ErrKeyAlreadyExists, cache.AddWithTTL("b", "b2", time.Hour))
+
+	_, ttl, ok := cache.GetWithTTL("a")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, ttl)
+	_, ttl, _ = cache.GetWithTTL("forever")
+	require.Equal(t, time.Duration(0), ttl)
+	value, ttl, _ := cache.GetWithTTL("b")
+	require.Equal(t, "b", value)
+	require.Equal(t, 2*time.Minute, ttl)
+
+	// Setting a resident key replaces its expiry.
+	cache.SetWithTTL("a", "a2", time.Hour)
+	value, ttl, _ = cache.GetWithTTL("a")
+	require.Equal(t, "a2", value)
+	require.Equal(t, time.Hour, ttl)
+
+	clock.Add(90 * time.Second)
+	_, ok = cache.Get("b")
+	require.True(t, ok)
+	clock.Add(time.Minute)
+	_, ok = cache.Get("b")
+	require.False(t, ok)
+	_, ok = cache.Get("forever")
+	require.True(t, ok)
+}
+
+func TestDefaultTTL(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock), tinylfu.WithDefaultTTL(time.Minute))
+
+	cache.Set(&tinylfu.Item{Key: "default", Value: "default"})
+	cache.SetWithTTL("zero", "zero", 0)
+	cache.SetWithTTL("ttl", "ttl", time.Hour)
+	cache.Set(&tinylfu.Item{Key: "explicit", Value: "explicit", ExpireAt: clock.Now().Add(time.Second)})
+
+	for key, want := range map[string]time.Duration{
+		"default":  time.Minute,
+		"zero":     time.Minute,
+		"ttl":      time.Hour,
+		"explicit": time.Second,
+	} {
+		_, ttl, ok := cache.GetWithTTL(key)
+		require.True(t, ok)
+		require.Equal(t, want, ttl, key)
+	}
+
+	// Replacing the value restarts the default TTL.
+	clock.Add(30 * time.Second)
+	cache.Set(&tinylfu.Item{Key: "default", Value: "default2"})
+	_, ttl, _ := cache.GetWithTTL("default")
+	require.Equal(t, time.Minute, ttl)
+
+	clock.Add(2 * time.Minute)
+	for _, key := range []string{"default", "zero", "explicit"} {
+		_, ok := cache.Get(key)
+		require.False(t, ok, key)
+	}
+	_, ok := cache.Get("ttl")
+	require.True(t, ok)
+}
+
+func TestTTLJitter(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(2000, 20000, tinylfu.WithClock(clock), tinylfu.WithTTLJitter(10*time.Second))
+
+	for i := 0; i < 1000; i++ {
+		cache.SetWithTTL(strconv.Itoa(i), i, time.Minute)
+	}
+	cache.SetWithTTL("forever", "forever", 0)
+
+	// Split the jitter window into 10 buckets of a second.
+	var buckets [10]int
+	for i := 0; i < 1000; i++ {
+		_, ttl, ok := cache.GetWithTTL(strconv.Itoa(i))
+		require.True(t, ok)
+		require.GreaterOrEqual(t, ttl, time.Minute)
+		require.Less(t, ttl, time.Minute+10*time.Second)
+		buckets[(ttl-time.Minute)/time.Second]++
+	}
+	for i, n := range buckets {
+		require.Greater(t, n, 50, "bucket %d", i)
+	}
+
+	_, ttl, ok := cache.GetWithTTL("forever")
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), ttl)
+}
+
+func TestSlidingTTL(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	cache.Set(&tinylfu.Item{Key: "used", Value: "used", SlidingTTL: time.Minute})
+	cache.Set(&tinylfu.Item{Key: "idle", Value: "idle", SlidingTTL: time.Minute})
+	for i := 0; i < 10; i++ {
+		cache.Set(&tinylfu.Item{Key: strconv.Itoa(i), Value: i})
+	}
+	cache.Set(&tinylfu.Item{Key: "window", Value: "window", SlidingTTL: time.Minute})
+
+	seg, _ := cache.SegmentOf("used")
+	require.Equal(t, tinylfu.SegmentProbation, seg)
+	seg, _ = cache.SegmentOf("window")
+	require.Equal(t, tinylfu.SegmentWindow, seg)
+
+	for i := 0; i < 10; i++ {
+		clock.Add(40 * time.Second)
+		_, ok := cache.Get("used")
+		require.True(t, ok, i)
+		r := cache.GetResult("window")
+		require.True(t, r.Found(), i)
+	}
+	seg, _ = cache.SegmentOf("used")
+	require.Equal(t, tinylfu.SegmentProtected, seg)
+	seg, _ = cache.SegmentOf("window")
+	require.Equal(t, tinylfu.SegmentWindow, seg)
+
+	_, ok := cache.Get("idle")
+	require.False(t, ok)
+	_, ttl, ok := cache.GetWithTTL("used")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, ttl)
+
+	// The cache-wide sliding TTL applies to items without their own.
+	cache = tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock), tinylfu.WithSlidingTTL(time.Minute))
+	cache.Set(&tinylfu.Item{Key: "used", Value: "used"})
+	cache.Set(&tinylfu.Item{Key: "idle", Value: "idle"})
+	cache.Set(&tinylfu.Item{Key: "long", Value: "long", SlidingTTL: time.Hour})
+	for i := 0; i < 3; i++ {
+		clock.Add(40 * time.Second)
+		_, ok = cache.Get("used")
+		require.True(t, ok, i)
+	}
+	_, ok = cache.Get("idle")
+	require.False(t, ok)
+	_, ok = cache.Get("long")
+	require.True(t, ok)
+}
+
+func TestGetAndExtend(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", ExpireAt: clock.Now().Add(time.Second)})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "expired", ExpireAt: clock.Now()})
+	clock.Add(time.Millisecond)
+
+	val, ok := cache.GetAndExtend("a", time.Minute)
+	require.True(t, ok)
+	require.Equal(t, "a", val)
+	require.Equal(t, clock.Now().Add(time.Minute), cache.MGetEntries([]string{"a"})["a"].ExpireAt)
+
+	_, ok = cache.GetAndExtend("expired", time.Minute)
+	require.False(t, ok)
+	_, ok = cache.GetAndExtend("missing", time.Minute)
+	require.False(t, ok)
+	_, ok = cache.Peek("missing")
+	require.False(t, ok)
+
+	// Concurrent extensions never let the entry lapse.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				_, ok := cache.GetAndExtend("a", time.Minute)
+				require.True(t, ok)
+			}
+		}()
+	}
+	wg.Wait()
+
+	clock.Add(time.Minute)
+	_, ok = cache.Get("a")
+	require.True(t, ok)
+	clock.Add(time.Nanosecond)
+	_, ok = cache.Get("a")
+	require.False(t, ok)
+}
+
+func TestGetAndExtendFromExpiry(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.New(100, 10000, tinylfu.WithClock(clock), tinylfu.WithExtendFromExpiry())
+
+	expireAt := clock.Now().Add(time.Second)
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", ExpireAt: expireAt})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "b"})
+
+	for i := 0; i < 3; i++ {
+		_, ok := cache.GetAndExtend("a", time.Minute)
+		require.True(t, ok)
+	}
+	_, ok := cache.GetAndExtend("b", time.Minute)
+	require.True(t, ok)
+
+	entries := cache.MGetEntries([]string{"a", "b"})
+	require.Equal(t, expireAt.Add(3*time.Minute), entries["a"].ExpireAt)
+	require.Equal(t, clock.Now().Add(time.Minute), entries["b"].ExpireAt)
+}
+
+func TestTouch(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", ExpireAt: clock.Now().Add(time.Second)})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "x", ExpireAt: clock.Now()})
+	clock.Add(time.Millisecond)
+	freq := cache.PeekResult("a").Frequency()
+
+	require.True(t, cache.Touch("a", clock.Now().Add(time.Hour)))
+	require.False(t, cache.Touch("expired", clock.Now().Add(time.Hour)))
+	require.False(t, cache.Touch("missing", clock.Now().Add(time.Hour)))
+	require.Equal(t, freq, cache.PeekResult("a").Frequency())
+
+	clock.Add(time.Minute)
+	val, ok := cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "a", val)
+	_, ok = cache.Get("expired")
+	require.False(t, ok)
+}
+
+func TestRefreshTTL(t *testing.T) {
+	clock := newManualClock()
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", ExpireAt: clock.Now().Add(time.Second)})
+	cache.Set(&tinylfu.Item{Key: "b", Value: "b"})
+	cache.Set(&tinylfu.Item{Key: "expired", Value: "expired", ExpireAt: clock.Now()})
+	clock.Add(time.Millisecond)
+
+	expireAt := clock.Now().Add(time.Hour)
+	n := cache.RefreshTTL([]string{"a", "b", "missing", "expired"}, expireAt)
+	require.Equal(t, 2, n)
+
+	clock.Add(time.Minute)
+	_, ok := cache.Get("a")
+	require.True(t, ok)
+	_, ok = cache.Get("expired")
+	require.False(t, ok)
+
+	clock.Add(time.Hour)
+	_, ok = cache.Get("a")
+	require.False(t, ok)
+	_, ok = cache.Get("b")
+	require.False(t, ok)
+}
@@ -0,0 +1,55 @@
+// Package tinylfuexpvar publishes the stats of a tinylfu cache with expvar.
+// It is separate from the tinylfu package because importing expvar registers
+// the /debug/vars handler on http.DefaultServeMux.
+package tinylfuexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+
+	"github.com/vmihailenco/go-tinylfu"
+)
+
+var _ expvar.Var = (*Var)(nil)
+
+// Var is an expvar.Var reading the Stats of a cache each time it is
+// formatted.
+type Var struct {
+	cache *tinylfu.SyncT
+}
+
+// NewVar returns a Var for cache without publishing it.
+func NewVar(cache *tinylfu.SyncT) *Var {
+	return &Var{cache: cache}
+}
+
+// ExportVar publishes the Stats of cache under name. Like expvar.Publish it
+// panics if name is already in use.
+func ExportVar(name string, cache *tinylfu.SyncT) *Var {
+	v := NewVar(cache)
+	expvar.Publish(name, v)
+	return v
+}
+
+// snapshot is Stats as encoded by Var. Durations are nanoseconds, and the
+// error is its message since errors have no JSON form.
+type snapshot struct {
+	tinylfu.Stats
+	LastCallbackError string `json:",omitempty"`
+}
+
+// String returns the current Stats of the cache as a JSON object with the
+// field names of Stats.
+func (v *Var) String() string {
+	s := snapshot{Stats: v.cache.Stats()}
+	if err := s.Stats.LastCallbackError; err != nil {
+		s.LastCallbackError = err.Error()
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		// Stats only has fields json can encode.
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,48 @@
+package tinylfuexpvar_test
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/go-tinylfu"
+	"github.com/vmihailenco/go-tinylfu/tinylfuexpvar"
+)
+
+func TestExportVar(t *testing.T) {
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithCallbackRecovery())
+	tinylfuexpvar.ExportVar("tinylfu_test", cache)
+
+	for i := 0; i < 150; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	cache.Get("149")
+	cache.Get("149")
+	cache.Get("missing")
+
+	var got struct {
+		Hits, Misses, TotalEvictions uint64
+		Size, Capacity               int
+		LastCallbackError            string
+	}
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("tinylfu_test").String()), &got))
+	require.Equal(t, uint64(2), got.Hits)
+	require.Equal(t, uint64(1), got.Misses)
+	require.Equal(t, uint64(50), got.TotalEvictions)
+	require.Equal(t, 100, got.Size)
+	require.Equal(t, 100, got.Capacity)
+	require.Empty(t, got.LastCallbackError)
+
+	// The stats are read again on every call.
+	cache.Set(&tinylfu.Item{Key: "a", Value: "a", OnEvict: func() { panic(errors.New("close failed")) }})
+	cache.Del("a")
+	cache.Get("missing")
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("tinylfu_test").String()), &got))
+	require.Equal(t, uint64(2), got.Misses)
+	require.Equal(t, "close failed", got.LastCallbackError)
+
+	require.Panics(t, func() { tinylfuexpvar.ExportVar("tinylfu_test", cache) })
+}
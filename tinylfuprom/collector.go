@@ -0,0 +1,65 @@
+// Package tinylfuprom publishes the stats of a tinylfu cache as Prometheus
+// metrics. It is a module of its own so that the tinylfu package doesn't
+// depend on the Prometheus client.
+package tinylfuprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmihailenco/go-tinylfu"
+)
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// Collector is a prometheus.Collector reading the Stats of a cache on every
+// scrape. The counters are the lifetime counters of the cache, so they only
+// reset when the process restarts.
+type Collector struct {
+	cache *tinylfu.SyncT
+
+	size        *prometheus.Desc
+	capacity    *prometheus.Desc
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	expirations *prometheus.Desc
+}
+
+// NewCollector returns a collector for cache. The metrics are named
+// namespace_tinylfu_*, or tinylfu_* with an empty namespace, and carry
+// constLabels, which tell caches apart when several are registered.
+func NewCollector(cache *tinylfu.SyncT, namespace string, constLabels prometheus.Labels) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "tinylfu", name), help, nil, constLabels)
+	}
+
+	return &Collector{
+		cache: cache,
+
+		size:        desc("size", "Number of resident entries."),
+		capacity:    desc("capacity", "Maximum number of resident entries."),
+		hits:        desc("hits_total", "Gets that found a live entry."),
+		misses:      desc("misses_total", "Gets that found no live entry."),
+		evictions:   desc("evictions_total", "Entries removed to make room."),
+		expirations: desc("expirations_total", "Entries removed because they expired."),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.capacity
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.expirations
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cache.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(stats.Size))
+	ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(stats.Capacity))
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.TotalEvictions))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(stats.Expirations))
+}
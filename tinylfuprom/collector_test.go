@@ -0,0 +1,69 @@
+package tinylfuprom_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/go-tinylfu"
+	"github.com/vmihailenco/go-tinylfu/tinylfuprom"
+)
+
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time { return c.now }
+
+func TestCollector(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	cache := tinylfu.NewSync(100, 10000, tinylfu.WithClock(clock))
+
+	for i := 0; i < 150; i++ {
+		cache.Set(&tinylfu.Item{Key: fmt.Sprint(i), Value: i})
+	}
+	cache.Set(&tinylfu.Item{Key: "ttl", Value: "ttl", ExpireAt: clock.now.Add(time.Minute)})
+	cache.Get("ttl")
+	cache.Get("missing")
+	clock.now = clock.now.Add(2 * time.Minute)
+	cache.Get("ttl")
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(tinylfuprom.NewCollector(cache, "app", prometheus.Labels{"cache": "users"}))
+
+	stats := cache.Stats()
+	expected := fmt.Sprintf(`
+# HELP app_tinylfu_capacity Maximum number of resident entries.
+# TYPE app_tinylfu_capacity gauge
+app_tinylfu_capacity{cache="users"} 100
+# HELP app_tinylfu_evictions_total Entries removed to make room.
+# TYPE app_tinylfu_evictions_total counter
+app_tinylfu_evictions_total{cache="users"} %d
+# HELP app_tinylfu_expirations_total Entries removed because they expired.
+# TYPE app_tinylfu_expirations_total counter
+app_tinylfu_expirations_total{cache="users"} 1
+# HELP app_tinylfu_hits_total Gets that found a live entry.
+# TYPE app_tinylfu_hits_total counter
+app_tinylfu_hits_total{cache="users"} 1
+# HELP app_tinylfu_misses_total Gets that found no live entry.
+# TYPE app_tinylfu_misses_total counter
+app_tinylfu_misses_total{cache="users"} 2
+# HELP app_tinylfu_size Number of resident entries.
+# TYPE app_tinylfu_size gauge
+app_tinylfu_size{cache="users"} %d
+`, stats.TotalEvictions, stats.Size)
+	require.NotZero(t, stats.TotalEvictions)
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+
+	// Every scrape reads the current stats.
+	cache.Get("missing")
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP app_tinylfu_misses_total Gets that found no live entry.
+# TYPE app_tinylfu_misses_total counter
+app_tinylfu_misses_total{cache="users"} 3
+`), "app_tinylfu_misses_total"))
+}
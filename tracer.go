@@ -0,0 +1,39 @@
+package tinylfu
+
+// Tracer receives cache events, e.g. to attach them as events to the current
+// span of a tracing system. Every event has a "key" attribute, and eviction
+// and expiry events also have a "reason".
+//
+// The events are:
+//
+//	tinylfu.admit   an item left the window and entered the main cache
+//	tinylfu.evict   an item was dropped, with reason "capacity" when it was
+//	                evicted to make room, "rejected" when it lost admission
+//	                and "deleted" when it was deleted
+//	tinylfu.expire  an expired item was removed, with reason "expired"
+type Tracer interface {
+	AddEvent(name string, attrs map[string]interface{})
+}
+
+const (
+	eventAdmit  = "tinylfu.admit"
+	eventEvict  = "tinylfu.evict"
+	eventExpire = "tinylfu.expire"
+
+	reasonCapacity = "capacity"
+	reasonRejected = "rejected"
+	reasonDeleted  = "deleted"
+	reasonExpired  = "expired"
+)
+
+func (t *T) trace(name, key, reason string) {
+	if t.opts.tracer == nil {
+		return
+	}
+
+	attrs := map[string]interface{}{"key": key}
+	if reason != "" {
+		attrs["reason"] = reason
+	}
+	t.opts.tracer.AddEvent(name, attrs)
+}
@@ -0,0 +1,197 @@
+package tinylfu
+
+import "time"
+
+// RefreshTTL sets ExpireAt of every resident, non-expired key in keys to
+// expireAt and returns the number of keys updated. Missing and expired keys
+// are skipped. It does not count as an access.
+func (t *T) RefreshTTL(keys []string, expireAt time.Time) int {
+	now := t.opts.clock.Now()
+
+	var n int
+	for _, key := range keys {
+		t.flushPending(key)
+
+		val, ok := t.data[key]
+		if !ok {
+			continue
+		}
+
+		item := val.Value.(*Item)
+		if item.expired(now) {
+			continue
+		}
+
+		item.ExpireAt = expireAt
+		n++
+	}
+
+	return n
+}
+
+// GetAndExtend is like Get but on a hit also sets ExpireAt of the entry to now
+// plus extension, or to its current ExpireAt plus extension with
+// WithExtendFromExpiry. Entries stored without ExpireAt get one. Missing and
+// expired keys are left untouched.
+func (t *T) GetAndExtend(key string, extension time.Duration) (interface{}, bool) {
+	if t.coalesce != nil {
+		t.flushKey(key)
+		t.flushDue()
+	}
+
+	value, ok := t.get(key)
+	if ok {
+		// get may have moved the entry to another item.
+		item := t.data[key].Value.(*Item)
+		base := t.opts.clock.Now()
+		if t.opts.extendFromExpiry && !item.ExpireAt.IsZero() {
+			base = item.ExpireAt
+		}
+		item.ExpireAt = base.Add(extension)
+	}
+	t.observe(key, ok)
+
+	return value, ok
+}
+
+// GetAndExtend reads and extends the entry under one write lock, so no
+// concurrent operation sees it expire or change in between.
+func (t *SyncT) GetAndExtend(key string, extension time.Duration) (interface{}, bool) {
+	t.mu.Lock()
+	val, ok := t.t.GetAndExtend(key, extension)
+	t.mu.Unlock()
+
+	return val, ok
+}
+
+// SetWithTTL stores value under key with an ExpireAt of ttl from now, see Set.
+// A ttl <= 0 leaves ExpireAt zero, so WithDefaultTTL applies if it is set and
+// the entry never expires otherwise.
+func (t *T) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	t.Set(t.ttlItem(key, value, ttl))
+}
+
+// AddWithTTL is like SetWithTTL but fails like Add if the key is already
+// in the cache.
+func (t *T) AddWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return t.Add(t.ttlItem(key, value, ttl))
+}
+
+func (t *T) ttlItem(key string, value interface{}, ttl time.Duration) *Item {
+	item := &Item{
+		Key:   key,
+		Value: value,
+	}
+	if ttl > 0 {
+		item.ExpireAt = t.opts.clock.Now().Add(ttl)
+	}
+	return item
+}
+
+// expireAt returns the ExpireAt of newItem, or the one WithDefaultTTL or the
+// sliding TTL gives it, plus the WithTTLJitter offset.
+func (t *T) expireAt(newItem *Item) time.Time {
+	expireAt := newItem.ExpireAt
+	if expireAt.IsZero() && t.opts.defaultTTL > 0 {
+		expireAt = t.opts.clock.Now().Add(t.opts.defaultTTL)
+	}
+	if d := t.slidingTTL(newItem); expireAt.IsZero() && d > 0 {
+		expireAt = t.opts.clock.Now().Add(d)
+	}
+	if !expireAt.IsZero() && t.opts.ttlJitter > 0 {
+		expireAt = expireAt.Add(time.Duration(t.jitter.next() % uint64(t.opts.ttlJitter)))
+	}
+	return expireAt
+}
+
+// slidingTTL returns the sliding TTL of item, see Item.SlidingTTL.
+func (t *T) slidingTTL(item *Item) time.Duration {
+	if item.SlidingTTL > 0 {
+		return item.SlidingTTL
+	}
+	return t.opts.slidingTTL
+}
+
+// slide restarts the sliding TTL of item on a hit at now.
+func (t *T) slide(item *Item, now time.Time) {
+	if d := t.slidingTTL(item); d > 0 {
+		item.ExpireAt = now.Add(d)
+	}
+}
+
+func (t *SyncT) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	t.mu.Lock()
+	t.t.SetWithTTL(key, value, ttl)
+	t.mu.Unlock()
+}
+
+func (t *SyncT) AddWithTTL(key string, value interface{}, ttl time.Duration) error {
+	t.mu.Lock()
+	err := t.t.AddWithTTL(key, value, ttl)
+	t.mu.Unlock()
+
+	return err
+}
+
+// GetWithTTL is like Get but also returns how long the value remains valid,
+// measured with the cache clock up to ExpireAt or the WithMaxAge limit,
+// whichever comes first. The ttl is 0 for entries that never expire.
+func (t *T) GetWithTTL(key string) (value interface{}, ttl time.Duration, ok bool) {
+	if t.coalesce != nil {
+		t.flushKey(key)
+		t.flushDue()
+	}
+
+	value, ok = t.get(key)
+	if ok {
+		// get may have moved the entry to another item.
+		item := t.data[key].Value.(*Item)
+		if expireAt := item.expiresAt(); !expireAt.IsZero() {
+			ttl = expireAt.Sub(t.opts.clock.Now())
+		}
+	}
+	t.observe(key, ok)
+
+	return value, ttl, ok
+}
+
+func (t *SyncT) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	t.mu.Lock()
+	val, ttl, ok := t.t.GetWithTTL(key)
+	t.mu.Unlock()
+
+	return val, ttl, ok
+}
+
+// expiresAt returns when the item expires, the earlier of ExpireAt and the
+// WithMaxAge limit, or the zero time if it never does.
+func (item *Item) expiresAt() time.Time {
+	if item.staleAt.IsZero() || !item.ExpireAt.IsZero() && item.ExpireAt.Before(item.staleAt) {
+		return item.ExpireAt
+	}
+	return item.staleAt
+}
+
+// Touch sets ExpireAt of key to expireAt without replacing its value, see
+// RefreshTTL. It returns false if the key is missing or expired. Like
+// RefreshTTL it does not count as an access, so touching an entry doesn't make
+// it more likely to be kept.
+func (t *T) Touch(key string, expireAt time.Time) bool {
+	return t.RefreshTTL([]string{key}, expireAt) == 1
+}
+
+func (t *SyncT) Touch(key string, expireAt time.Time) bool {
+	t.mu.Lock()
+	ok := t.t.Touch(key, expireAt)
+	t.mu.Unlock()
+
+	return ok
+}
+
+func (t *SyncT) RefreshTTL(keys []string, expireAt time.Time) int {
+	t.mu.Lock()
+	n := t.t.RefreshTTL(keys, expireAt)
+	t.mu.Unlock()
+
+	return n
+}
@@ -0,0 +1,59 @@
+package tinylfu
+
+import "time"
+
+// TypedLFU is a facade over an LFU holding values of type V, see Typed.
+type TypedLFU[V any] struct {
+	cache LFU
+}
+
+// Typed wraps cache, usually a *T or a *SyncT, so that values are read and
+// written as V with the type assertion done once, inside the facade. A value
+// of another type, e.g. stored through the raw cache by other code, is
+// reported as a miss: Get returns the zero V and false instead of panicking.
+// The entry itself is left in the cache.
+func Typed[V any](cache LFU) *TypedLFU[V] {
+	return &TypedLFU[V]{cache: cache}
+}
+
+// Get returns the value stored under key, or the zero V and false if the key
+// is missing, expired or holds a value that isn't a V.
+func (c *TypedLFU[V]) Get(key string) (V, bool) {
+	val, ok := c.cache.Get(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	v, ok := val.(V)
+	return v, ok
+}
+
+// Set stores value under key, see T.Set. A ttl > 0 sets ExpireAt to ttl from
+// now by the wall clock, not the Clock of the cache; otherwise the entry
+// doesn't expire.
+func (c *TypedLFU[V]) Set(key string, value V, ttl time.Duration) {
+	c.cache.Set(typedItem(key, value, ttl))
+}
+
+// Add stores value under key unless the key exists, see T.Add. ttl is the
+// same as for Set.
+func (c *TypedLFU[V]) Add(key string, value V, ttl time.Duration) error {
+	return c.cache.Add(typedItem(key, value, ttl))
+}
+
+// Del removes key, see T.Del.
+func (c *TypedLFU[V]) Del(key string) {
+	c.cache.Del(key)
+}
+
+func typedItem[V any](key string, value V, ttl time.Duration) *Item {
+	item := &Item{
+		Key:   key,
+		Value: value,
+	}
+	if ttl > 0 {
+		item.ExpireAt = time.Now().Add(ttl)
+	}
+	return item
+}